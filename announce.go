@@ -0,0 +1,79 @@
+package bencode
+
+// AnnounceParams holds the standard fields of a tracker announce request.
+type AnnounceParams struct {
+	InfoHash   [20]byte
+	PeerID     [20]byte
+	IP         string // optional
+	Port       int
+	Uploaded   int
+	Downloaded int
+	Left       int
+	Event      string // "started", "completed", "stopped" or empty
+	Compact    bool
+	NumWant    int    // optional, omitted when zero
+	Key        string // optional
+	TrackerID  string // optional
+}
+
+// AnnounceResponse holds the standard fields of a tracker announce response.
+// Peers is either a compact string of 6-byte records or a list of
+// dictionaries with "peer id", "ip" and "port" keys, depending on the tracker.
+type AnnounceResponse struct {
+	FailureReason  string `bencode:"failure reason"`
+	WarningMessage string `bencode:"warning message"`
+	Interval       int    `bencode:"interval"`
+	MinInterval    int    `bencode:"min interval"`
+	TrackerID      string `bencode:"tracker id"`
+	Complete       int    `bencode:"complete"`
+	Incomplete     int    `bencode:"incomplete"`
+	Peers          any    `bencode:"peers"`
+}
+
+// EncodeAnnounce returns the bencoded dictionary form of an announce request.
+// Optional fields are omitted when empty.
+func EncodeAnnounce(params AnnounceParams) ([]byte, error) {
+	compact := 0
+	if params.Compact {
+		compact = 1
+	}
+
+	req := map[string]any{
+		"info_hash":  string(params.InfoHash[:]),
+		"peer_id":    string(params.PeerID[:]),
+		"port":       params.Port,
+		"uploaded":   params.Uploaded,
+		"downloaded": params.Downloaded,
+		"left":       params.Left,
+		"compact":    compact,
+	}
+	if params.IP != "" {
+		req["ip"] = params.IP
+	}
+	if params.Event != "" {
+		req["event"] = params.Event
+	}
+	if params.NumWant != 0 {
+		req["numwant"] = params.NumWant
+	}
+	if params.Key != "" {
+		req["key"] = params.Key
+	}
+	if params.TrackerID != "" {
+		req["trackerid"] = params.TrackerID
+	}
+
+	return appendValue(nil, req)
+}
+
+// DecodeAnnounceResponse decodes a tracker announce response. A response
+// carrying a failure reason is returned without error; callers should check
+// FailureReason.
+func DecodeAnnounceResponse(data []byte) (*AnnounceResponse, error) {
+	d := newBytesDecoder(data)
+	var resp AnnounceResponse
+	if err := d.Decode(&resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
@@ -0,0 +1,51 @@
+package bencode
+
+import "unsafe"
+
+// Arena is a bump allocator for the strings produced while decoding. A
+// Decoder using an arena copies each string into the arena's buffer instead
+// of allocating it separately, so decoding many documents in turn can reuse
+// one buffer.
+//
+// Strings decoded with an arena refer to its memory. After Reset they may
+// change as later documents overwrite the buffer, so they must not be used
+// once the arena is reset; copy any string that needs to outlive it. This
+// includes strings stored in struct fields and map keys. Byte slices such as
+// []byte fields and RawMessage values are always copied and are unaffected.
+// An Arena must not be used by several decoders at the same time.
+type Arena struct {
+	buf []byte
+}
+
+// NewArena returns an arena whose buffer starts with the given capacity.
+// The buffer grows as needed.
+func NewArena(size int) *Arena {
+	return &Arena{buf: make([]byte, 0, size)}
+}
+
+// Reset makes the arena's memory available for reuse, invalidating every
+// string decoded with it.
+func (a *Arena) Reset() {
+	a.buf = a.buf[:0]
+}
+
+// string copies b into the arena and returns a string referring to the copy.
+func (a *Arena) string(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	if len(b) > cap(a.buf)-len(a.buf) {
+		// Strings handed out so far keep the old buffer alive.
+		a.buf = make([]byte, 0, max(2*cap(a.buf), len(b)))
+	}
+
+	start := len(a.buf)
+	a.buf = append(a.buf, b...)
+	return unsafe.String(&a.buf[start], len(b))
+}
+
+// UseArena makes the decoder allocate decoded strings from a. Pass nil to
+// return to ordinary allocation.
+func (d *Decoder) UseArena(a *Arena) {
+	d.arena = a
+}
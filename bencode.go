@@ -1,18 +1,188 @@
 package bencode
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"math"
+	"math/big"
+	"net"
+	"net/netip"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Decoder struct {
+	// MaxIntDigits bounds the number of digits accepted in an integer token,
+	// not counting the sign. NewDecoder sets it to DefaultMaxIntDigits, which
+	// covers every int64; raise it to decode larger values into Number, or set
+	// it to 0 to disable the limit.
+	MaxIntDigits int
+
+	// BoolStrings maps additional string values, such as "y"/"n" or "t"/"f",
+	// to the boolean they represent. It is consulted before the built-in
+	// "true"/"false"/"1"/"0" forms when decoding a string into a bool.
+	BoolStrings map[string]bool
+
+	// MaxBytes, when positive, limits how far into the input the decoder may
+	// read; decoding past it fails with ErrInputTooLarge. NewDecoder buffers
+	// the whole reader before options can be set, so wrap the reader in an
+	// io.LimitReader to also bound memory.
+	MaxBytes int
+
+	// MaxKeyLen, when positive, limits the length of dictionary keys.
+	// Absurdly long keys usually indicate corrupt or hostile input.
+	MaxKeyLen int
+
+	// MaxDepth bounds how deeply lists and dictionaries may nest, so that
+	// input such as a long run of 'l' bytes fails with a SyntaxError instead
+	// of exhausting the stack. NewDecoder sets it to DefaultMaxDepth; set it
+	// to 0 to disable the limit.
+	MaxDepth int
+
+	// CollectErrors makes Decode keep going when a decoded value cannot be
+	// stored in its destination, for example an integer meeting a struct
+	// field. The offending field, list element or map entry is left unset
+	// and all such errors are returned together as a MultiError once
+	// decoding finishes. Malformed input still stops decoding immediately,
+	// since nothing after a SyntaxError can be trusted.
+	CollectErrors bool
+
+	// ExactFloats rejects integers that would lose precision when stored in
+	// a float field, such as counters above 2^53 in a float64. With
+	// CollectErrors set, these are reported like any other type mismatch.
+	ExactFloats bool
+
+	// IntType selects the Go type used for integers stored in interface
+	// values, including the values of a map[string]any. The zero value keeps
+	// the historical int, which is convenient but delivers integers beyond
+	// the int range as Number. IntTypeInt64 has the same range on 64-bit
+	// platforms but a fixed width everywhere, and IntTypeBigInt represents
+	// every integer exactly at the cost of an allocation per integer.
+	// Struct fields and other typed destinations are unaffected.
+	IntType IntType
+
+	// SchemaKey names the dictionary key holding the integer version used to
+	// pick a schema registered with RegisterSchema. It defaults to "version"
+	// when empty.
+	SchemaKey string
+
+	// AcceptLeadingZeros makes the decoder accept integers and string
+	// lengths with leading zeros, such as i03e or 05:hello, and the negative
+	// zero i-0e, which some buggy clients write and which are rejected by
+	// default. They are parsed as if the zeros, or the sign, were absent.
+	// This recovers such files, but re-encoding them changes their bytes, and
+	// so any hash computed over them, such as the info-hash.
+	AcceptLeadingZeros bool
+
+	// KeepTrailing makes Decode fill its target from the first top-level
+	// value only, instead of wrapping several values in a list, and keep the
+	// values after it for Remaining. This suits formats with a known header
+	// followed by optional extras.
+	KeepTrailing bool
+
+	// KeyTransform, when set, is applied to dictionary keys before they are
+	// matched against struct field names, for example strings.ToLower to
+	// match keys regardless of case. Tag names and "|" aliases are compared
+	// with the transformed keys as written, so they should already be in
+	// transformed form. When several keys transform to the same name, the
+	// first in sorted order is used. Map destinations, key prefixes and
+	// schema versions see the original keys.
+	KeyTransform func(string) string
+
+	// LazyStrings makes interface destinations receive byte strings as
+	// LazyString values referring to the input instead of copies. Strings
+	// decoded into typed destinations are copied as usual.
+	LazyStrings bool
+
+	// OnField, when set, is called with every key-value pair of every
+	// dictionary as it is decoded, for side effects such as indexing without
+	// a second traversal. Pairs are reported in input order once their value
+	// is complete, so the pairs of a nested dictionary come before the pair
+	// holding it. Values are in the generic form used for interface
+	// destinations and must not be modified.
+	OnField func(key string, value any)
+
+	// RecordKeyPositions makes the decoder record where each dictionary key
+	// appears in the input, for source mapping in editors and validators;
+	// see KeyPositions. Nothing is recorded when it is unset.
+	RecordKeyPositions bool
+
+	// CollectStats makes the decoder count the values it decodes, for
+	// reporting through Stats.
+	CollectStats bool
+
 	rawBytes []byte
 	curToken int
+
+	typeHooks map[reflect.Type]func([]byte) (any, error)
+	errs      MultiError
+	presence  map[string]bool
+	arena     *Arena
+	stats     DecodeStats
+
+	// path holds the dictionary keys and list indexes leading to the value
+	// being decoded or filled, for KeyPositions and DecodeWithPresence.
+	path []string
+
+	prefixHandlers map[string]func(string, []byte) error
+
+	schemas map[int]map[string]bool
+
+	enums map[reflect.Type]map[int64]bool
+
+	discriminatorKey string
+	registeredTypes  map[string]reflect.Type
+
+	// listStack and dictStack hold the elements of the containers being
+	// decoded until their final size is known.
+	listStack []any
+	dictStack []dictEntry
+
+	// dictOffsets records where each decoded dictionary starts, keyed by the
+	// map's pointer, so RawMessage destinations can recover exact bytes. It
+	// is only filled when recordDicts is set.
+	dictOffsets map[uintptr]int
+	recordDicts bool
+	valuesStart int
+	valuesEnd   int
+
+	remaining []any
+
+	keyPositions map[string]int
+
+	// open holds the containers being decoded or skipped, innermost last,
+	// so that truncated input can be blamed on the container it cuts short.
+	open []openContainer
+
+	// depth is the nesting level of the container currently being decoded.
+	depth int
+	// infoStart and infoEnd delimit the raw bytes of the top-level "info"
+	// dictionary value, used to compute the info-hash.
+	infoStart, infoEnd int
 }
 
+// ErrInputTooLarge is returned when decoding would read past Decoder.MaxBytes.
+var ErrInputTooLarge = errors.New("input exceeds the decoder's byte limit")
+
+// DefaultMaxIntDigits is the default value of Decoder.MaxIntDigits.
+const DefaultMaxIntDigits = 19
+
+// DefaultMaxDepth is the default value of Decoder.MaxDepth. Real documents
+// nest a handful of levels deep.
+const DefaultMaxDepth = 512
+
 const (
 	integer   byte = 'i'
 	lists     byte = 'l'
@@ -29,244 +199,2083 @@ func NewDecoder(r io.ReadCloser) (Decoder, error) {
 	if err != nil {
 		return Decoder{}, err
 	}
-	defer r.Close()
-	if len(bytes) == 0 {
-		return Decoder{}, io.EOF
+	defer r.Close()
+	if len(bytes) == 0 {
+		return Decoder{}, io.EOF
+	}
+	return newBytesDecoder(bytes), nil
+}
+
+// newBytesDecoder returns a Decoder with default options reading from data.
+func newBytesDecoder(data []byte) Decoder {
+	return Decoder{rawBytes: data, curToken: 0, MaxIntDigits: DefaultMaxIntDigits, MaxDepth: DefaultMaxDepth}
+}
+
+// NewDecoderAuto is like NewDecoder but transparently decompresses input that
+// starts with a gzip or zlib header, such as a .torrent.gz file.
+func NewDecoderAuto(r io.ReadCloser) (Decoder, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(2)
+
+	var src io.Reader = br
+	switch {
+	case isGzip(magic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			r.Close()
+			return Decoder{}, err
+		}
+		src = zr
+	case isZlib(magic):
+		zr, err := zlib.NewReader(br)
+		if err != nil {
+			r.Close()
+			return Decoder{}, err
+		}
+		src = zr
+	}
+
+	return NewDecoder(readCloser{Reader: src, Closer: r})
+}
+
+func isGzip(magic []byte) bool {
+	return len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b
+}
+
+// isZlib checks for a deflate CMF byte followed by a FLG byte whose check
+// bits make the 16-bit header a multiple of 31, as required by RFC 1950.
+// Headers asking for a preset dictionary are not accepted: compressed
+// torrents never use one, and "80:" at the start of a plain document would
+// otherwise pass for such a header.
+func isZlib(magic []byte) bool {
+	return len(magic) == 2 && magic[0]&0x0f == 8 && magic[1]&0x20 == 0 &&
+		(uint16(magic[0])<<8|uint16(magic[1]))%31 == 0
+}
+
+// readCloser pairs a (possibly decompressing) reader with the Closer of the
+// underlying source.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func (d *Decoder) curTokenIs() byte {
+	if d.curToken >= len(d.rawBytes) {
+		return 0
+	}
+	return d.rawBytes[d.curToken]
+}
+
+func (d *Decoder) advance() {
+	if d.curToken < len(d.rawBytes) {
+		d.curToken++
+	}
+}
+
+// Decode decodes Bencode encoded data.
+//
+// When the destination is an empty interface, values are stored as int for
+// integers (Number for integers that do not fit in an int, see IntType for
+// alternatives), string for byte strings, []any for lists and map[string]any
+// for dictionaries. A net.TCPAddr or net.UDPAddr is decoded from a
+// "host:port" string whose host is an IP literal, and a []Peer from either
+// form of tracker peer list.
+//
+// Struct fields are matched to dictionary keys by the name in their bencode
+// tag, or by the field name when there is none, and a tag of "-" skips the
+// field. A name may list alternative keys separated by "|", such as
+// "announce-list|announce_list"; they are tried in order and the first key
+// present in the dictionary is used. Options may follow the name after a
+// comma:
+//
+//   - infohash: fill a [20]byte or string field with the SHA1 of the raw
+//     top-level "info" dictionary instead of a dictionary value.
+//   - document: fill a RawMessage or []byte field with the complete input
+//     document the outermost value was decoded from, not a dictionary
+//     value. Fields in nested structs receive the same bytes.
+//   - listmap: decode a list into an integer-keyed map, keyed by index.
+//   - flatten: the key's value must be a dictionary with exactly one entry,
+//     whatever its key, and that entry's value is decoded into the field as
+//     if it had appeared directly under the field's key. Dictionaries with
+//     zero or several entries are an error, since there is no single value
+//     to pick. Other options apply to the unwrapped value.
+//   - pairs: decode a list of [key, value] lists into a map.
+//   - bigint: interpret a byte string as a big-endian unsigned integer and
+//     store it in a big.Int or *big.Int field. This differs from decoding an
+//     integer token, whose digits are decimal.
+//   - stringify: store an integer in a string field as its decimal form.
+//   - split=sep: decode a string into a slice by splitting it on sep, for
+//     encoders that pack a list into one string. An empty string yields an
+//     empty slice. A comma separator is written "split=,". Lists still
+//     decode as usual.
+//   - trim: remove leading and trailing whitespace from a string stored in
+//     a string field. Byte slices and other destinations are unaffected.
+//   - keyfield: when the struct is decoded as the value of a map, fill the
+//     field with the entry's key instead of a dictionary value. The field is
+//     left untouched anywhere else.
+//   - rfc3339: parse an RFC 3339 timestamp string, such as
+//     "2006-01-02T15:04:05Z", into a time.Time field.
+//   - compact6: decode a string of 18-byte BEP 7 records, each an IPv6
+//     address and a big-endian port, into a []net.TCPAddr or []Peer.
+//   - derive=Method: fill the field by calling the named method, with
+//     signature func() (T, error) for a T assignable to the field, once all
+//     other fields are set and before Validate. It is typically combined
+//     with a "-" name, as in `bencode:"-,derive=InfoHashHex"`, and the
+//     field is never read from the dictionary.
+//   - default=value: when the key is absent, parse value as if it were a
+//     string in the input and store it in the field. The value cannot
+//     contain a comma.
+func (d *Decoder) Decode(v any) error {
+	if err := checkTarget(v); err != nil {
+		return err
+	}
+	d.trackDictOffsets(v)
+
+	if d.KeepTrailing {
+		return d.decodeFirst(v)
+	}
+
+	results, err := d.decodeAll()
+	if err != nil {
+		return err
+	}
+
+	return d.fill(results, v)
+}
+
+// decodeFirst fills v from the first top-level value and keeps the rest for
+// Remaining. It implements KeepTrailing.
+func (d *Decoder) decodeFirst(v any) error {
+	d.remaining = nil
+	start := d.curToken
+	first, err := d.decode()
+	if err != nil {
+		return err
+	}
+	if err := d.checkMaxBytes(d.curToken); err != nil {
+		return err
+	}
+	end := d.curToken
+
+	rest, err := d.decodeAll()
+	if err != nil {
+		return err
+	}
+	d.valuesStart, d.valuesEnd = start, end
+	for i, val := range rest {
+		rest[i] = d.convertInts(val)
+	}
+	d.remaining = rest
+
+	return d.fill([]any{first}, v)
+}
+
+// Remaining returns the top-level values that followed the first one in the
+// last call to Decode with KeepTrailing set, in the generic form used for
+// empty interface destinations.
+func (d *Decoder) Remaining() []any {
+	return d.remaining
+}
+
+// DecodeWithPresence decodes into v like Decode and also reports which
+// dictionary keys were matched to struct fields, telling a field left zero
+// because its key was absent apart from one decoded from a zero value. Keys
+// of nested structs are reported as dotted paths of the keys, list indexes
+// and map keys leading to them, in the syntax of KeyPositions, such as
+// "info.files[0].length".
+func (d *Decoder) DecodeWithPresence(v any) (present map[string]bool, err error) {
+	present = make(map[string]bool)
+	d.presence, d.path = present, nil
+	defer func() { d.presence, d.path = nil, nil }()
+
+	if err := d.Decode(v); err != nil {
+		return nil, err
+	}
+	return present, nil
+}
+
+// tracksPath reports whether the path to the current value is needed, by
+// RecordKeyPositions while decoding or DecodeWithPresence while filling.
+func (d *Decoder) tracksPath() bool {
+	return d.RecordKeyPositions || d.presence != nil
+}
+
+// enterKey and enterIndex extend the path with a dictionary key or a list
+// index, and leavePath removes the last element again.
+func (d *Decoder) enterKey(key string) {
+	if d.tracksPath() {
+		if len(d.path) > 0 {
+			key = "." + key
+		}
+		d.path = append(d.path, key)
+	}
+}
+
+func (d *Decoder) enterIndex(i int) {
+	if d.tracksPath() {
+		d.path = append(d.path, "["+strconv.Itoa(i)+"]")
+	}
+}
+
+func (d *Decoder) leavePath() {
+	if d.tracksPath() {
+		d.path = d.path[:len(d.path)-1]
+	}
+}
+
+// pathString formats the current path, such as "info.files[0].length".
+func (d *Decoder) pathString() string {
+	return strings.Join(d.path, "")
+}
+
+// DecodeCanonical decodes into v like Decode and also returns the canonical
+// encoding of the decoded values, with dictionary keys sorted at every level.
+func (d *Decoder) DecodeCanonical(v any) ([]byte, error) {
+	if err := checkTarget(v); err != nil {
+		return nil, err
+	}
+	d.trackDictOffsets(v)
+
+	results, err := d.decodeAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var canonical []byte
+	for _, result := range results {
+		if canonical, err = appendValue(canonical, result); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := d.fill(results, v); err != nil {
+		return nil, err
+	}
+
+	return canonical, nil
+}
+
+// DecodeInto decodes a single dictionary and merges its entries into m.
+// Entries of m whose keys are absent from the input are kept; the merge is
+// shallow, so a nested dictionary replaces the previous value of its key.
+func (d *Decoder) DecodeInto(m map[string]any) error {
+	if m == nil {
+		return fmt.Errorf("DecodeInto requires a non-nil map")
+	}
+
+	results, err := d.decodeAll()
+	if err != nil {
+		return err
+	}
+	if len(results) != 1 {
+		return fmt.Errorf("expected a single dictionary, got %d values", len(results))
+	}
+	dict, ok := results[0].(map[string]any)
+	if !ok {
+		return fmt.Errorf("expected a dictionary, got %s", valueKind(results[0]))
+	}
+
+	maps.Copy(m, d.convertInts(dict).(map[string]any))
+	return nil
+}
+
+// Decode decodes data into a new value of type T and returns it, as a
+// shorthand for creating a Decoder and passing it a pointer.
+func Decode[T any](data []byte) (T, error) {
+	var v T
+	if len(data) == 0 {
+		return v, io.EOF
+	}
+
+	d := newBytesDecoder(data)
+	err := d.Decode(&v)
+	return v, err
+}
+
+// UnmarshalFrame decodes exactly one value from the front of data into v and
+// returns the bytes following it, for protocols that put a bencoded header
+// in front of a binary payload.
+func UnmarshalFrame(data []byte, v any) (rest []byte, err error) {
+	if err := checkTarget(v); err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, io.EOF
+	}
+
+	d := newBytesDecoder(data)
+	d.trackDictOffsets(v)
+	val, err := d.decode()
+	if err != nil {
+		return nil, err
+	}
+	d.valuesEnd = d.curToken
+	if err := d.fill([]any{val}, v); err != nil {
+		return nil, err
+	}
+
+	return data[d.curToken:], nil
+}
+
+// DecodeFramed reads one length-prefixed frame from r and decodes its
+// contents into v. A frame is a 4-byte big-endian length followed by that
+// many bytes of bencode, as used by some wire protocols. Input ending within
+// the frame yields io.ErrUnexpectedEOF, and a zero-length frame, which
+// cannot hold a value, is an error. Nothing past the frame is read from r.
+func DecodeFramed(r io.Reader, v any) error {
+	if err := checkTarget(v); err != nil {
+		return err
+	}
+
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(prefix[:])
+	if n == 0 {
+		return fmt.Errorf("frame is empty")
+	}
+
+	// Read through a LimitReader rather than allocating n bytes up front,
+	// so a bogus length cannot force a huge allocation.
+	data, err := io.ReadAll(io.LimitReader(r, int64(n)))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) < int64(n) {
+		return io.ErrUnexpectedEOF
+	}
+
+	d := newBytesDecoder(data)
+	return d.Decode(v)
+}
+
+// UnmarshalStream decodes each top-level value of a stream of concatenated
+// documents in turn and passes it to fn, in the generic form used for empty
+// interface destinations. It stops at the first syntax error or error
+// returned by fn, reporting the zero-based index of the document involved.
+// Documents before a malformed one have already been passed to fn.
+func UnmarshalStream(data []byte, fn func(v any) error) error {
+	d := newBytesDecoder(data)
+	for i := 0; d.curToken < len(d.rawBytes); i++ {
+		val, err := d.decode()
+		if err != nil {
+			return fmt.Errorf("document %d: %w", i, err)
+		}
+		if err := fn(val); err != nil {
+			return fmt.Errorf("document %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// FindFirst decodes inputs in order until pred reports true for one of them,
+// and returns its index and decoded value. Inputs after the match are not
+// decoded. Values are in the generic form used for empty interface
+// destinations, with several top-level values wrapped in a list as Decode
+// does. It returns -1 if nothing matches, and stops at the first input that
+// fails to decode.
+func FindFirst(inputs [][]byte, pred func(any) bool) (int, any, error) {
+	for i, input := range inputs {
+		if len(input) == 0 {
+			return -1, nil, fmt.Errorf("input %d: %w", i, io.EOF)
+		}
+
+		d := newBytesDecoder(input)
+		results, err := d.decodeAll()
+		if err != nil {
+			return -1, nil, fmt.Errorf("input %d: %w", i, err)
+		}
+
+		var val any = results
+		if len(results) == 1 {
+			val = results[0]
+		}
+		if pred(val) {
+			return i, val, nil
+		}
+	}
+	return -1, nil, nil
+}
+
+// decodeAll decodes every remaining top-level value.
+func (d *Decoder) decodeAll() ([]any, error) {
+	var results []any
+	d.valuesStart = d.curToken
+
+	for d.curToken < len(d.rawBytes) {
+		val, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		if err := d.checkMaxBytes(d.curToken); err != nil {
+			return nil, err
+		}
+		results = append(results, val)
+	}
+	d.valuesEnd = d.curToken
+
+	return results, nil
+}
+
+// fill stores the decoded top-level values in v. A single value is stored
+// as-is; several values are stored as a list.
+func (d *Decoder) fill(results []any, v any) error {
+	d.errs = nil
+
+	if raw, ok := v.(*RawMessage); ok {
+		*raw = bytes.Clone(d.rawBytes[d.valuesStart:d.valuesEnd])
+		return nil
+	}
+
+	var err error
+	if len(results) == 1 {
+		err = d.fillStruct(results[0], reflect.ValueOf(v))
+	} else {
+		err = d.fillStruct(results, reflect.ValueOf(v))
+	}
+
+	if err == nil && len(d.errs) > 0 {
+		err = d.errs
+	}
+	return err
+}
+
+// collect records err and returns nil when CollectErrors is set, so that
+// filling continues with the next value; otherwise it returns err unchanged.
+func (d *Decoder) collect(err error) error {
+	if err == nil || !d.CollectErrors {
+		return err
+	}
+	d.errs = append(d.errs, err)
+	return nil
+}
+
+// checkTarget ensures v can be decoded into, so that reflection never has to
+// set an unaddressable value.
+func checkTarget(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("decode target must be a non-nil pointer, got %T", v)
+	}
+	return nil
+}
+
+func (d *Decoder) decodeString() (string, error) {
+	b, err := d.decodeStringBytes()
+	if err != nil {
+		return "", err
+	}
+	return d.copyString(b), nil
+}
+
+// copyString returns b as a string, allocated from the arena when there is
+// one.
+func (d *Decoder) copyString(b []byte) string {
+	if d.arena != nil {
+		return d.arena.string(b)
+	}
+	return string(b)
+}
+
+// decodeStringBytes reads a string token and returns its contents, which
+// alias the input.
+func (d *Decoder) decodeStringBytes() ([]byte, error) {
+	start := d.curToken
+
+	// Read until we reach the colon ':'
+	for d.curToken < len(d.rawBytes) && d.curTokenIs() != colon {
+		if d.curTokenIs() < asciiZero || d.curTokenIs() > asciiNine {
+			return nil, d.syntaxError("invalid character in string length: %c", d.curTokenIs())
+		}
+		d.advance()
+	}
+
+	if d.curToken >= len(d.rawBytes) {
+		return nil, d.eofError("unexpected EOF while reading string length")
+	}
+	if err := d.checkStringLengthForm(start); err != nil {
+		return nil, err
+	}
+	lengthStr := string(d.rawBytes[start:d.curToken])
+
+	d.advance()
+
+	length, err := strconv.Atoi(lengthStr)
+	if err != nil {
+		return nil, d.syntaxError("invalid string length: %s", lengthStr)
+	}
+
+	// Lengths are compared with what is left rather than added to the
+	// offset, which could overflow for lengths near the int limit.
+	if length < 0 || length > len(d.rawBytes)-d.curToken {
+		return nil, d.eofError("unexpected EOF: string is shorter than its length %d", length)
+	}
+	if err := d.checkMaxLength(length); err != nil {
+		return nil, err
+	}
+
+	data := d.rawBytes[d.curToken : d.curToken+length : d.curToken+length]
+	d.curToken += length
+
+	return data, nil
+}
+
+// LazyString is a byte string that has not been copied out of the input. A
+// Decoder with LazyStrings set stores LazyString instead of string in
+// interface values, so that large values such as "pieces" cost nothing until
+// they are used. Each one keeps the whole input alive.
+type LazyString struct {
+	data []byte
+}
+
+// String returns a copy of the string's contents.
+func (s LazyString) String() string {
+	return string(s.data)
+}
+
+// Bytes returns a copy of the string's contents.
+func (s LazyString) Bytes() []byte {
+	return bytes.Clone(s.data)
+}
+
+// Len returns the length of the string without copying it.
+func (s LazyString) Len() int {
+	return len(s.data)
+}
+
+// materialize replaces a LazyString with the string it refers to, for
+// destinations other than interfaces.
+func materialize(data any) any {
+	if s, ok := data.(LazyString); ok {
+		return s.String()
+	}
+	return data
+}
+
+// Number holds the decimal digits of a bencode integer exactly as they were
+// decoded. Using it as a destination preserves integers of any size, subject
+// to Decoder.MaxIntDigits. Integers that do not fit in an int are also
+// delivered as a Number when decoding into an interface value.
+type Number string
+
+var numberType = reflect.TypeOf(Number(""))
+
+// IntType is the set of integer representations for Decoder.IntType.
+type IntType int
+
+const (
+	IntTypeInt    IntType = iota // int, or Number when out of range
+	IntTypeInt64                 // int64, or Number when out of range
+	IntTypeBigInt                // *big.Int
+)
+
+// convertInts returns data with its integers converted to the representation
+// selected by IntType. Lists and dictionaries are copied rather than modified,
+// since the decoded tree may be shared with other destinations.
+func (d *Decoder) convertInts(data any) any {
+	if d.IntType == IntTypeInt {
+		return data
+	}
+
+	switch v := data.(type) {
+	case int:
+		if d.IntType == IntTypeBigInt {
+			return big.NewInt(int64(v))
+		}
+		return int64(v)
+	case Number:
+		if d.IntType == IntTypeBigInt {
+			if n, ok := new(big.Int).SetString(string(v), 10); ok {
+				return n
+			}
+		}
+		return v
+	case []any:
+		list := make([]any, len(v))
+		for i, item := range v {
+			list[i] = d.convertInts(item)
+		}
+		return list
+	case map[string]any:
+		dict := make(map[string]any, len(v))
+		for k, item := range v {
+			dict[k] = d.convertInts(item)
+		}
+		return dict
+	default:
+		return data
+	}
+}
+
+func (d *Decoder) decodeInteger() (any, error) {
+	d.advance()
+
+	start := d.curToken
+	if d.curTokenIs() == '-' {
+		d.advance()
+	}
+	digitsStart := d.curToken
+
+	// Read digits until we hit 'e'
+	for d.curToken < len(d.rawBytes) && d.curTokenIs() != end {
+		if d.curTokenIs() < asciiZero || d.curTokenIs() > asciiNine {
+			return 0, d.syntaxError("invalid character in integer: %c", d.curTokenIs())
+		}
+		if err := d.checkIntDigits(d.curToken - digitsStart + 1); err != nil {
+			return 0, err
+		}
+		d.advance()
+	}
+
+	if d.curToken >= len(d.rawBytes) {
+		return 0, d.eofError("unexpected EOF while reading integer")
+	}
+	if err := d.checkIntDigitsForm(digitsStart); err != nil {
+		return 0, err
+	}
+	numStr := string(d.rawBytes[start:d.curToken])
+
+	d.advance() // Skip the 'e'
+	if err := d.checkMaxBytes(d.curToken); err != nil {
+		return 0, err
+	}
+
+	num, err := strconv.Atoi(numStr)
+	if errors.Is(err, strconv.ErrRange) {
+		if d.AcceptLeadingZeros {
+			sign, digits := numStr[:digitsStart-start], numStr[digitsStart-start:]
+			numStr = sign + strings.TrimLeft(digits, "0")
+		}
+		return Number(numStr), nil
+	} else if err != nil {
+		return 0, d.syntaxError("invalid integer: %s", numStr)
+	}
+
+	return num, nil
+}
+
+// checkMaxBytes reports whether consuming input up to offset n stays within
+// MaxBytes.
+func (d *Decoder) checkMaxBytes(n int) error {
+	if d.MaxBytes > 0 && n > d.MaxBytes {
+		return ErrInputTooLarge
+	}
+	return nil
+}
+
+// checkMaxLength reports whether consuming n more bytes from the current
+// position stays within MaxBytes, without computing an offset that could
+// overflow.
+func (d *Decoder) checkMaxLength(n int) error {
+	if d.MaxBytes > 0 && n > d.MaxBytes-d.curToken {
+		return ErrInputTooLarge
+	}
+	return nil
+}
+
+// checkIntDigitsForm rejects the digits of an integer, running from start
+// up to the current position, when there are none, and unless
+// AcceptLeadingZeros is set, when they have leading zeros or make a negative
+// zero.
+func (d *Decoder) checkIntDigitsForm(start int) error {
+	switch {
+	case d.curToken == start:
+		return d.syntaxErrorAt(start, "integer has no digits")
+	case d.AcceptLeadingZeros || d.rawBytes[start] != asciiZero:
+		return nil
+	case d.curToken-start > 1:
+		return d.syntaxErrorAt(start, "integer has leading zeros")
+	case d.rawBytes[start-1] == '-':
+		return d.syntaxErrorAt(start-1, "negative zero is not a valid integer")
+	}
+	return nil
+}
+
+// checkStringLengthForm rejects string length digits, running from start up
+// to the current position, that have leading zeros, unless
+// AcceptLeadingZeros is set.
+func (d *Decoder) checkStringLengthForm(start int) error {
+	if !d.AcceptLeadingZeros && d.curToken-start > 1 && d.rawBytes[start] == asciiZero {
+		return d.syntaxErrorAt(start, "string length has leading zeros")
+	}
+	return nil
+}
+
+func (d *Decoder) checkIntDigits(n int) error {
+	if d.MaxIntDigits > 0 && n > d.MaxIntDigits {
+		return d.syntaxError("integer has more than %d digits", d.MaxIntDigits)
+	}
+	return nil
+}
+
+type openContainer struct {
+	kind  string
+	start int
+}
+
+// openContainer records the start of a list or dictionary, failing if it
+// would nest deeper than MaxDepth.
+func (d *Decoder) openContainer(kind string) error {
+	if d.MaxDepth > 0 && len(d.open) >= d.MaxDepth {
+		return d.syntaxError("%s nested deeper than %d levels", kind, d.MaxDepth)
+	}
+	d.open = append(d.open, openContainer{kind: kind, start: d.curToken})
+	return nil
+}
+
+func (d *Decoder) closeContainer() {
+	d.open = d.open[:len(d.open)-1]
+}
+
+// eofError reports input that ends too early. Inside a container, the
+// message is prefixed with the innermost container left open and where it
+// starts, since that is what a truncated document is missing.
+func (d *Decoder) eofError(format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+	if len(d.open) > 0 {
+		c := d.open[len(d.open)-1]
+		return d.syntaxError("%s starting at offset %d: %s", c.kind, c.start, msg)
+	}
+	return d.syntaxError("%s", msg)
+}
+
+func (d *Decoder) decodeList() ([]any, error) {
+	if err := d.openContainer("list"); err != nil {
+		return nil, err
+	}
+	defer d.closeContainer()
+	d.advance() // Skip over the 'l'
+	d.depth++
+	defer func() { d.depth-- }()
+
+	// Elements are gathered on a stack shared by all nested lists, so that
+	// each list is allocated once at its final size.
+	base := len(d.listStack)
+	defer func() {
+		clear(d.listStack[base:])
+		d.listStack = d.listStack[:base]
+	}()
+
+	// Read values until we hit 'e'
+	for d.curToken < len(d.rawBytes) && d.curTokenIs() != end {
+		d.enterIndex(len(d.listStack) - base)
+		value, err := d.decode()
+		d.leavePath()
+		if err != nil {
+			return nil, err
+		}
+		d.listStack = append(d.listStack, value)
+	}
+
+	if d.curToken >= len(d.rawBytes) {
+		return nil, d.eofError("unexpected EOF: list is missing its closing 'e'")
+	}
+
+	d.advance() // Skip the 'e'
+
+	var result []any
+	if n := len(d.listStack) - base; n > 0 {
+		result = make([]any, n)
+		copy(result, d.listStack[base:])
+	}
+	return result, nil
+}
+
+func (d *Decoder) decodeDict() (map[string]any, error) {
+	start := d.curToken
+	if err := d.openContainer("dictionary"); err != nil {
+		return nil, err
+	}
+	defer d.closeContainer()
+	d.advance() // Skip over the 'd'
+	d.depth++
+	defer func() { d.depth-- }()
+
+	// Like decodeList, entries are staged on a shared stack so the map can
+	// be created with its final size.
+	base := len(d.dictStack)
+	defer func() {
+		clear(d.dictStack[base:])
+		d.dictStack = d.dictStack[:base]
+	}()
+
+	for d.curToken < len(d.rawBytes) && d.curTokenIs() != end {
+		if !(d.curTokenIs() >= asciiZero && d.curTokenIs() <= asciiNine) {
+			return nil, d.syntaxError("dictionary key must be a string")
+		}
+		keyStart := d.curToken
+		keyBytes, err := d.decodeStringBytes() // Decode the key
+		if err != nil {
+			return nil, err
+		}
+		// Check the length before copying, so an oversized key costs
+		// nothing beyond reading its length.
+		if d.MaxKeyLen > 0 && len(keyBytes) > d.MaxKeyLen {
+			return nil, d.syntaxErrorAt(keyStart, "dictionary key length %d exceeds limit of %d", len(keyBytes), d.MaxKeyLen)
+		}
+		key := d.copyString(keyBytes)
+		if d.curToken >= len(d.rawBytes) {
+			return nil, d.eofError("unexpected EOF: missing value for dictionary key %q", key)
+		}
+		valueStart := d.curToken
+		d.enterKey(key)
+		if d.RecordKeyPositions {
+			d.recordKeyPosition(keyStart)
+		}
+		value, err := d.decode() // Decode the value
+		d.leavePath()
+		if err != nil {
+			return nil, err
+		}
+		if key == "info" && d.depth == 1 && d.infoEnd == 0 {
+			d.infoStart, d.infoEnd = valueStart, d.curToken
+		}
+
+		if d.OnField != nil {
+			d.OnField(key, value)
+		}
+
+		d.dictStack = append(d.dictStack, dictEntry{key: key, value: value})
+	}
+
+	if d.curToken >= len(d.rawBytes) {
+		return nil, d.eofError("unexpected EOF: dictionary is missing its closing 'e'")
+	}
+
+	d.advance() // skip the e
+
+	result := make(map[string]any, len(d.dictStack)-base)
+	for _, entry := range d.dictStack[base:] {
+		result[entry.key] = entry.value
+	}
+
+	if d.recordDicts {
+		if d.dictOffsets == nil {
+			d.dictOffsets = make(map[uintptr]int)
+		}
+		d.dictOffsets[reflect.ValueOf(result).Pointer()] = start
+	}
+
+	return result, nil
+}
+
+// KeyPositions returns the offset of every dictionary key decoded since
+// RecordKeyPositions was set, keyed by its path from the top-level value,
+// such as "info.files[0].length". The offset is that of the key's length
+// prefix. Keys containing '.' or '[' make paths ambiguous.
+func (d *Decoder) KeyPositions() map[string]int {
+	return d.keyPositions
+}
+
+// recordKeyPosition records the offset of the dictionary key ending the
+// current path.
+func (d *Decoder) recordKeyPosition(offset int) {
+	if d.keyPositions == nil {
+		d.keyPositions = make(map[string]int)
+	}
+	d.keyPositions[d.pathString()] = offset
+}
+
+type dictEntry struct {
+	key   string
+	value any
+}
+
+func (d *Decoder) decode() (any, error) {
+	if d.curToken >= len(d.rawBytes) {
+		return nil, io.EOF
+	}
+	if err := d.checkMaxBytes(d.curToken + 1); err != nil {
+		return nil, err
+	}
+
+	curToken := d.curTokenIs()
+	if d.CollectStats {
+		d.countToken(curToken)
+	}
+	switch {
+	case curToken == null:
+		return nil, d.syntaxError("unexpected NUL byte")
+	case curToken == integer:
+		return d.decodeInteger()
+	case curToken == lists:
+		return d.decodeList()
+	case curToken == dict:
+		return d.decodeDict()
+	case curToken >= asciiZero && curToken <= asciiNine:
+		if d.LazyStrings {
+			b, err := d.decodeStringBytes()
+			return LazyString{data: b}, err
+		}
+		return d.decodeString()
+	default:
+		return nil, d.syntaxError("unknown token: %c", curToken)
+	}
+}
+
+// DecodeStats counts the values read by a Decoder with CollectStats set.
+type DecodeStats struct {
+	Strings  int // Byte strings, not counting dictionary keys
+	Integers int
+	Lists    int
+	Dicts    int
+	Bytes    int // Input consumed so far
+}
+
+// Stats returns the counts gathered since CollectStats was set. They
+// accumulate across calls to Decode.
+func (d *Decoder) Stats() DecodeStats {
+	stats := d.stats
+	stats.Bytes = d.curToken
+	return stats
+}
+
+func (d *Decoder) countToken(token byte) {
+	switch {
+	case token == integer:
+		d.stats.Integers++
+	case token == lists:
+		d.stats.Lists++
+	case token == dict:
+		d.stats.Dicts++
+	case token >= asciiZero && token <= asciiNine:
+		d.stats.Strings++
+	}
+}
+
+// RegisterTypeHook registers fn as the conversion for destinations of type t.
+// The hook receives the bencode encoding of the value being decoded, with
+// dictionary keys in sorted order, and must return a value assignable to t.
+// Hooks take precedence over every built-in conversion, including the
+// handling of pointers, structs and struct tag options for that type.
+//
+// Hooks also cover types the decoder cannot fill on its own. For example, a
+// dictionary can be loaded into a *sync.Map field:
+//
+//	d.RegisterTypeHook(reflect.TypeOf((*sync.Map)(nil)), func(raw []byte) (any, error) {
+//		dec, err := bencode.NewDecoder(io.NopCloser(bytes.NewReader(raw)))
+//		if err != nil {
+//			return nil, err
+//		}
+//		var entries map[string]any
+//		if err := dec.Decode(&entries); err != nil {
+//			return nil, err
+//		}
+//		m := new(sync.Map)
+//		for k, v := range entries {
+//			m.Store(k, v)
+//		}
+//		return m, nil
+//	})
+//
+// Hooks match the destination type exactly: a hook for *sync.Map is not used
+// for a sync.Map field. Because the result is assigned by copy, types that
+// must not be copied, like sync.Map, should be registered by pointer. The
+// decoder a hook creates internally does not inherit the outer hooks.
+func (d *Decoder) RegisterTypeHook(t reflect.Type, fn func([]byte) (any, error)) {
+	if d.typeHooks == nil {
+		d.typeHooks = make(map[reflect.Type]func([]byte) (any, error))
+	}
+	d.typeHooks[t] = fn
+}
+
+// RegisterEnum restricts integer type t, such as a named Status type with
+// defined constants, to the given values. Decoding any other integer into a
+// value of type t fails, which catches corrupt enum fields. Types that are
+// not registered accept every integer that fits. Registering t again
+// replaces its values.
+func (d *Decoder) RegisterEnum(t reflect.Type, values ...int64) {
+	if d.enums == nil {
+		d.enums = make(map[reflect.Type]map[int64]bool)
+	}
+	valid := make(map[int64]bool, len(values))
+	for _, v := range values {
+		valid[v] = true
+	}
+	d.enums[t] = valid
+}
+
+// checkEnum reports whether an integer just stored in val is one of the
+// values registered for its type.
+func (d *Decoder) checkEnum(val reflect.Value) error {
+	valid, ok := d.enums[val.Type()]
+	if !ok {
+		return nil
+	}
+
+	if val.CanInt() {
+		if num := val.Int(); !valid[num] {
+			val.SetZero()
+			return fmt.Errorf("%d is not a valid %v", num, val.Type())
+		}
+	} else if num := val.Uint(); num > math.MaxInt64 || !valid[int64(num)] {
+		val.SetZero()
+		return fmt.Errorf("%d is not a valid %v", num, val.Type())
+	}
+	return nil
+}
+
+// SetDiscriminatorKey names the dictionary key whose string value selects a
+// type registered with RegisterType. Every interface-typed destination then
+// receives a value of the registered type when it is given a dictionary with
+// this key, without needing a tag or hook on each field.
+func (d *Decoder) SetDiscriminatorKey(key string) {
+	d.discriminatorKey = key
+}
+
+// RegisterType associates a discriminator value with the concrete type
+// decoded for it; see SetDiscriminatorKey. Only the discriminator value is
+// considered, so at most one type can match a dictionary, and registering a
+// value again replaces the earlier type. The type is stored in the interface
+// directly when it implements it, otherwise a pointer to it is stored.
+// Dictionaries without the key, or with an unregistered value, decode as if
+// no types were registered. Interface types, and pointers to them, are
+// ignored, since they name no concrete type to decode into.
+func (d *Decoder) RegisterType(value string, t reflect.Type) {
+	if t == nil || indirectType(t).Kind() == reflect.Interface {
+		return
+	}
+	if d.registeredTypes == nil {
+		d.registeredTypes = make(map[string]reflect.Type)
+	}
+	d.registeredTypes[value] = t
+}
+
+// registeredType returns the type registered for a dictionary's
+// discriminator value.
+func (d *Decoder) registeredType(data any) (reflect.Type, bool) {
+	dict, ok := data.(map[string]any)
+	if !ok || d.discriminatorKey == "" {
+		return nil, false
+	}
+	value, ok := materialize(dict[d.discriminatorKey]).(string)
+	if !ok {
+		return nil, false
+	}
+	t, ok := d.registeredTypes[value]
+	return t, ok
+}
+
+// setRegisteredType decodes data into a new value of type t and stores it in
+// the interface val.
+func (d *Decoder) setRegisteredType(val reflect.Value, t reflect.Type, data any) error {
+	ptr := reflect.New(t)
+	if err := d.setReflectValue(ptr.Elem(), data); err != nil {
+		return err
+	}
+
+	switch {
+	case t.AssignableTo(val.Type()):
+		val.Set(ptr.Elem())
+	case ptr.Type().AssignableTo(val.Type()):
+		val.Set(ptr)
+	default:
+		return fmt.Errorf("registered type %v does not implement %v", t, val.Type())
+	}
+	return nil
+}
+
+func (d *Decoder) applyTypeHook(val reflect.Value, data any, fn func([]byte) (any, error)) error {
+	raw, err := appendValue(nil, data)
+	if err != nil {
+		return err
+	}
+	out, err := fn(raw)
+	if err != nil {
+		return err
+	}
+
+	result := reflect.ValueOf(out)
+	if !result.IsValid() {
+		val.SetZero()
+		return nil
+	}
+	if !result.Type().AssignableTo(val.Type()) {
+		return fmt.Errorf("type hook for %v returned value of type %v", val.Type(), result.Type())
+	}
+	val.Set(result)
+
+	return nil
+}
+
+// Skip advances the decoder past the next complete value, including any
+// nested lists and dictionaries, without building it.
+func (d *Decoder) Skip() error {
+	return d.skip()
+}
+
+func (d *Decoder) skip() error {
+	if d.curToken >= len(d.rawBytes) {
+		if len(d.open) > 0 {
+			return d.eofError("unexpected EOF: missing dictionary value")
+		}
+		return io.EOF
+	}
+	if err := d.checkMaxBytes(d.curToken + 1); err != nil {
+		return err
+	}
+
+	curToken := d.curTokenIs()
+	switch {
+	case curToken == null:
+		return d.syntaxError("unexpected NUL byte")
+	case curToken == integer:
+		d.advance() // Skip over the 'i'
+		if d.curTokenIs() == '-' {
+			d.advance()
+		}
+		start := d.curToken
+		for d.curToken < len(d.rawBytes) && d.curTokenIs() != end {
+			if d.curTokenIs() < asciiZero || d.curTokenIs() > asciiNine {
+				return d.syntaxError("invalid character in integer: %c", d.curTokenIs())
+			}
+			if err := d.checkIntDigits(d.curToken - start + 1); err != nil {
+				return err
+			}
+			d.advance()
+		}
+		if d.curToken >= len(d.rawBytes) {
+			return d.eofError("unexpected EOF while reading integer")
+		}
+		if err := d.checkIntDigitsForm(start); err != nil {
+			return err
+		}
+		d.advance() // Skip the 'e'
+		return d.checkMaxBytes(d.curToken)
+	case curToken == lists, curToken == dict:
+		kind := "list"
+		if curToken == dict {
+			kind = "dictionary"
+		}
+		if err := d.openContainer(kind); err != nil {
+			return err
+		}
+		defer d.closeContainer()
+		d.advance() // Skip over the 'l' or 'd'
+		for d.curToken < len(d.rawBytes) && d.curTokenIs() != end {
+			if curToken == dict {
+				if !(d.curTokenIs() >= asciiZero && d.curTokenIs() <= asciiNine) {
+					return d.syntaxError("dictionary key must be a string")
+				}
+				if err := d.skip(); err != nil { // Skip the key
+					return err
+				}
+			}
+			if err := d.skip(); err != nil {
+				return err
+			}
+		}
+		if d.curToken >= len(d.rawBytes) {
+			return d.eofError("unexpected EOF: %s is missing its closing 'e'", kind)
+		}
+		d.advance() // Skip the 'e'
+		return d.checkMaxBytes(d.curToken)
+	case curToken >= asciiZero && curToken <= asciiNine:
+		start := d.curToken
+		for d.curToken < len(d.rawBytes) && d.curTokenIs() != colon {
+			if d.curTokenIs() < asciiZero || d.curTokenIs() > asciiNine {
+				return d.syntaxError("invalid character in string length: %c", d.curTokenIs())
+			}
+			d.advance()
+		}
+		if d.curToken >= len(d.rawBytes) {
+			return d.eofError("unexpected EOF while reading string length")
+		}
+		if err := d.checkStringLengthForm(start); err != nil {
+			return err
+		}
+		length, err := strconv.Atoi(string(d.rawBytes[start:d.curToken]))
+		if err != nil {
+			return d.syntaxError("invalid string length: %s", d.rawBytes[start:d.curToken])
+		}
+		d.advance() // Skip the ':'
+		if length < 0 || length > len(d.rawBytes)-d.curToken {
+			return d.eofError("unexpected EOF: string is shorter than its length %d", length)
+		}
+		if err := d.checkMaxLength(length); err != nil {
+			return err
+		}
+		d.curToken += length
+		return nil
+	default:
+		return d.syntaxError("unknown token: %c", curToken)
+	}
+}
+
+func (d *Decoder) fillStruct(data any, val reflect.Value) error {
+	if val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			val.Set(reflect.New(val.Type().Elem()))
+		}
+		return d.fillStruct(data, val.Elem())
+	}
+
+	if fn, ok := d.typeHooks[val.Type()]; ok {
+		return d.applyTypeHook(val, data, fn)
+	}
+
+	if dict, ok := data.(map[string]any); !ok {
+		return d.setReflectValue(val, data)
+	} else {
+		if val.Kind() != reflect.Struct {
+			// Maps and interfaces are filled by setReflectValue, which
+			// rejects any other non-struct destination.
+			return d.setReflectValue(val, dict)
+		}
+
+		var claimed map[string]bool
+		if len(d.prefixHandlers) > 0 {
+			claimed = make(map[string]bool)
+		}
+		var raws map[string]RawMessage
+		var derived []derivedField
+		schema := d.schemaFor(dict)
+		lookup, original := dict, map[string]string(nil)
+		if d.KeyTransform != nil {
+			lookup, original = transformKeys(dict, d.KeyTransform)
+		}
+
+		t := val.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			fieldVal := val.Field(i)
+
+			if !fieldVal.CanSet() {
+				continue // Skip unexported fields
+			}
+
+			tagName, opts := parseTag(field)
+			if method, ok := opts.Get("derive"); ok {
+				derived = append(derived, derivedField{index: i, method: method})
+				continue
+			}
+			if tagName == "-" {
+				continue // Skip fields tagged with "-"
+			}
+
+			if opts.Contains("infohash") {
+				if err := d.setInfoHash(fieldVal); err != nil {
+					if err := d.collect(err); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if opts.Contains("document") {
+				if err := d.setDocument(fieldVal); err != nil {
+					if err := d.collect(fmt.Errorf("field %s: %w", field.Name, err)); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if opts.Contains("keyfield") {
+				continue // Filled with the map key by setKeyField
+			}
+			if schema != nil && !schemaAllows(schema, tagName) {
+				continue // Not part of this dictionary's version
+			}
+
+			key, bencodeValue, exists := lookupKey(lookup, tagName)
+			if exists && original != nil {
+				key = original[key]
+			}
+			if claimed != nil && exists {
+				claimed[key] = true
+			}
+			if d.presence != nil && exists {
+				d.enterKey(key)
+				d.presence[d.pathString()] = true
+				d.leavePath()
+			}
+			if !exists {
+				if def, ok := opts.Get("default"); ok {
+					if err := d.setReflectValue(fieldVal, def); err != nil {
+						err = fmt.Errorf("invalid default %q for field %s: %w", def, field.Name, err)
+						if err := d.collect(err); err != nil {
+							return err
+						}
+					}
+				}
+				continue
+			}
+
+			if opts.Contains("flatten") {
+				unwrapped, err := flatten(bencodeValue)
+				if err != nil {
+					if err := d.collect(fmt.Errorf("field %s: %w", field.Name, err)); err != nil {
+						return err
+					}
+					continue
+				}
+				bencodeValue = unwrapped
+			}
+
+			if _, hooked := d.typeHooks[field.Type]; !hooked && isRawType(indirectType(field.Type)) && !opts.Contains("flatten") {
+				if raws == nil {
+					raws = d.rawEntries(dict)
+				}
+				if raw, ok := raws[key]; ok {
+					if err := setRaw(indirect(fieldVal), raw); err != nil {
+						if err := d.collect(fmt.Errorf("field %s: %w", field.Name, err)); err != nil {
+							return err
+						}
+					}
+					continue
+				}
+			}
+
+			if _, hooked := d.typeHooks[field.Type]; !hooked && !supportedKind(field.Type) {
+				err := fmt.Errorf("field %s: %v values cannot be decoded from bencode; "+
+					"tag the field with `bencode:\"-\"`, remove it or register a type hook", field.Name, field.Type)
+				if err := d.collect(err); err != nil {
+					return err
+				}
+				continue
+			}
+
+			d.enterKey(key)
+			err := d.setField(fieldVal, bencodeValue, opts)
+			d.leavePath()
+			if err != nil {
+				if err := d.collect(fmt.Errorf("field %s: %w", field.Name, err)); err != nil {
+					return err
+				}
+			}
+		}
+
+		if claimed != nil {
+			if err := d.routePrefixedKeys(dict, claimed); err != nil {
+				return err
+			}
+		}
+		if err := d.derive(val, derived); err != nil {
+			return err
+		}
+
+		return d.validate(val)
+	}
+}
+
+type derivedField struct {
+	index  int
+	method string
+}
+
+// derive fills fields tagged with "derive=Method" by calling the named
+// method once every other field is set. It implements the "derive" tag
+// option.
+func (d *Decoder) derive(val reflect.Value, fields []derivedField) error {
+	for _, f := range fields {
+		field := val.Type().Field(f.index)
+		if err := d.deriveField(val, field, f.method); err != nil {
+			if err := d.collect(fmt.Errorf("field %s: %w", field.Name, err)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Decoder) deriveField(val reflect.Value, field reflect.StructField, name string) error {
+	if !val.CanAddr() {
+		return fmt.Errorf("cannot call %s on an unaddressable %v", name, val.Type())
+	}
+	method := val.Addr().MethodByName(name)
+	if !method.IsValid() {
+		return fmt.Errorf("%v has no method %s", val.Type(), name)
+	}
+
+	mt := method.Type()
+	if mt.NumIn() != 0 || mt.NumOut() != 2 || !mt.Out(0).AssignableTo(field.Type) || mt.Out(1) != errorType {
+		return fmt.Errorf("method %s must have signature func() (%v, error)", name, field.Type)
+	}
+	out := method.Call(nil)
+	if err, _ := out[1].Interface().(error); err != nil {
+		return err
+	}
+	val.FieldByIndex(field.Index).Set(out[0])
+	return nil
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Validator is implemented by structs that check their own invariants, such
+// as a piece length being a power of two. Decode calls Validate on every
+// struct it fills from a dictionary, nested ones first, once all of the
+// struct's fields have been set. With CollectErrors set, the error is
+// collected like a type mismatch and decoding continues.
+type Validator interface {
+	Validate() error
+}
+
+func (d *Decoder) validate(val reflect.Value) error {
+	if !val.CanAddr() {
+		return nil
+	}
+	v, ok := val.Addr().Interface().(Validator)
+	if !ok {
+		return nil
+	}
+	if err := v.Validate(); err != nil {
+		return d.collect(fmt.Errorf("%v: %w", val.Type(), err))
+	}
+	return nil
+}
+
+// RegisterKeyPrefix routes dictionary keys that start with prefix, such as
+// "x_" extension keys, to fn. When a dictionary is decoded into a struct,
+// fn is called for every such key that no struct field claims, in sorted key
+// order, with the full key and the bencode encoding of its value (dictionary
+// keys sorted). When several registered prefixes match a key, the longest
+// one wins. Keys matching no prefix are ignored as usual, and nothing is
+// routed when decoding into maps or interfaces.
+func (d *Decoder) RegisterKeyPrefix(prefix string, fn func(key string, raw []byte) error) {
+	if d.prefixHandlers == nil {
+		d.prefixHandlers = make(map[string]func(string, []byte) error)
+	}
+	d.prefixHandlers[prefix] = fn
+}
+
+func (d *Decoder) routePrefixedKeys(dict map[string]any, claimed map[string]bool) error {
+	keys := make([]string, 0, len(dict))
+	for key := range dict {
+		if !claimed[key] {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		var fn func(string, []byte) error
+		longest := -1
+		for prefix, handler := range d.prefixHandlers {
+			if strings.HasPrefix(key, prefix) && len(prefix) > longest {
+				fn, longest = handler, len(prefix)
+			}
+		}
+		if fn == nil {
+			continue
+		}
+
+		raw, err := appendValue(nil, dict[key])
+		if err == nil {
+			err = fn(key, raw)
+		}
+		if err != nil {
+			if err := d.collect(fmt.Errorf("key %q: %w", key, err)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// RegisterSchema declares which keys a dictionary of the given version may
+// fill. template is a struct, or a pointer to one, whose fields name the keys
+// of that version in the usual way; its field types are ignored.
+//
+// When a struct is filled from a dictionary whose SchemaKey entry holds a
+// registered version, only the destination fields whose key, or one of whose
+// "|" alternatives, is named by that version's template are filled. The others
+// are left untouched, including their defaults, even if the key is present.
+// Dictionaries without the key, or with an unregistered version, fill every
+// field as usual. The check is made for each dictionary separately, so nested
+// dictionaries carry their own version. Registering a version again replaces
+// its template, and templates that are not structs are ignored.
+func (d *Decoder) RegisterSchema(version int, template any) {
+	t := reflect.TypeOf(template)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return
+	}
+
+	keys := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		name, _ := parseTag(t.Field(i))
+		for _, alias := range strings.Split(name, "|") {
+			keys[alias] = true
+		}
+	}
+
+	if d.schemas == nil {
+		d.schemas = make(map[int]map[string]bool)
+	}
+	d.schemas[version] = keys
+}
+
+// schemaFor returns the keys allowed by the schema registered for a
+// dictionary's version, or nil if no schema applies.
+func (d *Decoder) schemaFor(dict map[string]any) map[string]bool {
+	if len(d.schemas) == 0 {
+		return nil
+	}
+	key := d.SchemaKey
+	if key == "" {
+		key = "version"
+	}
+	version, ok := dict[key].(int)
+	if !ok {
+		return nil
+	}
+	return d.schemas[version]
+}
+
+// schemaAllows reports whether a field tagged with name is part of schema.
+func schemaAllows(schema map[string]bool, name string) bool {
+	for _, alias := range strings.Split(name, "|") {
+		if schema[alias] {
+			return true
+		}
+	}
+	return false
+}
+
+// flatten unwraps a single-entry dictionary to its only value. It implements
+// the "flatten" tag option.
+func flatten(data any) (any, error) {
+	dict, ok := data.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("flatten expects a dictionary, got %s", valueKind(data))
+	}
+	if len(dict) != 1 {
+		return nil, fmt.Errorf("flatten expects a single-entry dictionary, got %d entries", len(dict))
 	}
-	return Decoder{rawBytes: bytes, curToken: 0}, nil
+	for _, value := range dict {
+		return value, nil
+	}
+	return nil, nil
 }
 
-func (d *Decoder) curTokenIs() byte {
-	if d.curToken >= len(d.rawBytes) {
-		return 0
+// transformKeys returns dict keyed by the transformed keys, and a map from
+// each transformed key back to the original one.
+func transformKeys(dict map[string]any, transform func(string) string) (map[string]any, map[string]string) {
+	keys := slices.Sorted(maps.Keys(dict))
+	lookup := make(map[string]any, len(dict))
+	original := make(map[string]string, len(dict))
+	for _, key := range keys {
+		transformed := transform(key)
+		if _, ok := lookup[transformed]; ok {
+			continue
+		}
+		lookup[transformed] = dict[key]
+		original[transformed] = key
 	}
-	return d.rawBytes[d.curToken]
+	return lookup, original
 }
 
-func (d *Decoder) advance() {
-	if d.curToken < len(d.rawBytes) {
-		d.curToken++
+// lookupKey finds the key and value for a tag name, which may list several
+// accepted keys separated by "|". The first alias present in dict wins.
+func lookupKey(dict map[string]any, name string) (string, any, bool) {
+	for name != "" {
+		var alias string
+		alias, name, _ = strings.Cut(name, "|")
+		if value, ok := dict[alias]; ok {
+			return alias, value, true
+		}
 	}
+	return "", nil, false
 }
 
-// Decode decodes Bencode encoded data.
-func (d *Decoder) Decode(v any) error {
-	var results []any
+// setField stores a dictionary value in a struct field, applying the
+// conversions requested by the field's tag options. Type hooks still take
+// precedence over the options.
+func (d *Decoder) setField(val reflect.Value, data any, opts tagOptions) error {
+	if _, ok := d.typeHooks[val.Type()]; ok {
+		return d.setReflectValue(val, data)
+	}
+	if indirectType(val.Type()).Kind() != reflect.Interface {
+		data = materialize(data)
+	}
 
-	for d.curToken < len(d.rawBytes) {
-		val, err := d.decode()
-		if err != nil {
-			return err
+	if sep, ok := opts.Get("split"); ok {
+		if str, ok := data.(string); ok && indirect(val).Kind() == reflect.Slice {
+			data = splitString(str, sep)
 		}
-		results = append(results, val)
 	}
 
-	if len(results) == 1 {
-		return d.fillStruct(results[0], reflect.ValueOf(v))
+	switch {
+	case opts.Contains("listmap"):
+		return d.setListMap(indirect(val), data)
+	case opts.Contains("pairs"):
+		return d.setPairs(indirect(val), data)
+	case opts.Contains("rfc3339"):
+		return setRFC3339(indirect(val), data)
+	case opts.Contains("compact6"):
+		return setCompactPeers6(indirect(val), data)
+	case opts.Contains("bigint"):
+		return setBigInt(indirect(val), data)
+	case opts.Contains("stringify") && indirect(val).Kind() == reflect.String:
+		switch num := data.(type) {
+		case int:
+			data = strconv.Itoa(num)
+		case Number:
+			data = string(num)
+		}
+		return d.setReflectValue(val, data)
+	case opts.Contains("trim") && indirect(val).Kind() == reflect.String:
+		if str, ok := data.(string); ok {
+			data = strings.TrimSpace(str)
+		}
+		return d.setReflectValue(val, data)
+	default:
+		return d.setReflectValue(val, data)
 	}
+}
 
-	return d.fillStruct(results, reflect.ValueOf(v))
+// setDocument stores the bytes of the whole top-level document in a
+// RawMessage or []byte field. It implements the "document" tag option.
+func (d *Decoder) setDocument(val reflect.Value) error {
+	val = indirect(val)
+	if val.Kind() != reflect.Slice || val.Type().Elem().Kind() != reflect.Uint8 {
+		return fmt.Errorf("document field must be RawMessage or []byte, got %v", val.Type())
+	}
+	val.SetBytes(bytes.Clone(d.rawBytes[d.valuesStart:d.valuesEnd]))
+	return nil
 }
 
-func (d *Decoder) decodeString() (string, error) {
-	var lengthStr string
+// setKeyField stores a map key in the field tagged "keyfield" of a struct
+// decoded as that key's value. It implements the "keyfield" tag option.
+func (d *Decoder) setKeyField(val reflect.Value, key string) error {
+	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return nil
+		}
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return nil
+	}
 
-	// Read until we reach the colon ':'
-	for d.curToken < len(d.rawBytes) && d.curTokenIs() != colon {
-		if d.curTokenIs() < asciiZero || d.curTokenIs() > asciiNine {
-			return "", fmt.Errorf("invalid character in string length: %c", d.curTokenIs())
+	t := val.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if _, opts := parseTag(t.Field(i)); opts.Contains("keyfield") && val.Field(i).CanSet() {
+			if err := d.setReflectValue(val.Field(i), key); err != nil {
+				return fmt.Errorf("field %s: cannot store map key: %w", t.Field(i).Name, err)
+			}
 		}
-		lengthStr += string(d.curTokenIs())
-		d.advance()
 	}
+	return nil
+}
 
-	if d.curToken >= len(d.rawBytes) {
-		return "", fmt.Errorf("unexpected EOF while reading string length")
+// splitString splits a delimited string into list elements. It implements
+// the "split" tag option, where an empty separator stands for a comma since
+// the tag syntax leaves nothing after "split=,".
+func splitString(str, sep string) []any {
+	if str == "" {
+		return []any{}
+	}
+	if sep == "" {
+		sep = ","
 	}
 
-	d.advance()
+	parts := strings.Split(str, sep)
+	list := make([]any, len(parts))
+	for i, part := range parts {
+		list[i] = part
+	}
+	return list
+}
 
-	length, err := strconv.Atoi(lengthStr)
-	if err != nil {
-		return "", fmt.Errorf("invalid string length: %s", lengthStr)
+// setPairs fills a map from a list of [key, value] lists. It implements the
+// "pairs" tag option.
+func (d *Decoder) setPairs(val reflect.Value, data any) error {
+	list, ok := data.([]any)
+	if !ok {
+		return fmt.Errorf("cannot decode %s into pairs field", valueKind(data))
+	}
+	if val.Kind() != reflect.Map {
+		return fmt.Errorf("pairs field must be a map, got %v", val.Type())
 	}
 
-	if length < 0 || d.curToken+length > len(d.rawBytes) {
-		return "", fmt.Errorf("invalid string length or unexpected EOF")
+	if val.IsNil() {
+		val.Set(reflect.MakeMapWithSize(val.Type(), len(list)))
 	}
+	for i, item := range list {
+		pair, ok := item.([]any)
+		if !ok || len(pair) != 2 {
+			err := fmt.Errorf("list index %d: pair must be a 2-element list, got %s", i, valueKind(item))
+			if ok {
+				err = fmt.Errorf("list index %d: pair must be a 2-element list, got %d elements", i, len(pair))
+			}
+			if err := d.collect(err); err != nil {
+				return err
+			}
+			continue
+		}
 
-	data := string(d.rawBytes[d.curToken : d.curToken+length])
-	d.curToken += length
+		mapKey := reflect.New(val.Type().Key()).Elem()
+		mapVal := reflect.New(val.Type().Elem()).Elem()
+		err := d.setReflectValue(mapKey, pair[0])
+		if err == nil {
+			err = d.setReflectValue(mapVal, pair[1])
+		}
+		if err != nil {
+			if err := d.collect(fmt.Errorf("list index %d: %w", i, err)); err != nil {
+				return err
+			}
+			continue
+		}
 
-	return data, nil
+		val.SetMapIndex(mapKey, mapVal)
+	}
+
+	return nil
 }
 
-func (d *Decoder) decodeInteger() (int, error) {
-	d.advance()
+var bigIntType = reflect.TypeOf(big.Int{})
 
-	var numStr string
+// setBigInt interprets a byte string as a big-endian unsigned integer. It
+// implements the "bigint" tag option.
+func setBigInt(val reflect.Value, data any) error {
+	if val.Type() != bigIntType {
+		return fmt.Errorf("bigint field must be big.Int or *big.Int, got %v", val.Type())
+	}
+	str, ok := data.(string)
+	if !ok {
+		return fmt.Errorf("cannot decode %s into bigint field", valueKind(data))
+	}
+	val.Addr().Interface().(*big.Int).SetBytes([]byte(str))
+	return nil
+}
 
-	if d.curTokenIs() == '-' {
-		numStr = "-"
-		d.advance()
+var (
+	tcpAddrType = reflect.TypeOf(net.TCPAddr{})
+	udpAddrType = reflect.TypeOf(net.UDPAddr{})
+)
+
+// setAddr fills a net.TCPAddr or net.UDPAddr from a "host:port" string. The
+// host must be an IP literal or empty; names are not resolved while decoding.
+func setAddr(val reflect.Value, data any) error {
+	str, ok := data.(string)
+	if !ok {
+		return fmt.Errorf("cannot decode %s into %v", valueKind(data), val.Type())
 	}
 
-	// Read digits until we hit 'e'
-	for d.curToken < len(d.rawBytes) && d.curTokenIs() != end {
-		if d.curTokenIs() < asciiZero || d.curTokenIs() > asciiNine {
-			return 0, fmt.Errorf("invalid character in integer: %c", d.curTokenIs())
+	host, portStr, err := net.SplitHostPort(str)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %v", str, err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port in address %q", str)
+	}
+	var ip net.IP
+	var zone string
+	if host != "" {
+		addr, err := netip.ParseAddr(host)
+		if err != nil {
+			return fmt.Errorf("invalid IP in address %q", str)
 		}
-		numStr += string(d.curTokenIs())
-		d.advance()
+		ip, zone = net.IP(addr.WithZone("").AsSlice()), addr.Zone()
 	}
 
-	if d.curToken >= len(d.rawBytes) {
-		return 0, fmt.Errorf("unexpected EOF while reading integer")
+	if val.Type() == tcpAddrType {
+		val.Set(reflect.ValueOf(net.TCPAddr{IP: ip, Port: int(port), Zone: zone}))
+	} else {
+		val.Set(reflect.ValueOf(net.UDPAddr{IP: ip, Port: int(port), Zone: zone}))
 	}
+	return nil
+}
 
-	d.advance() // Skip the 'e'
+var timeType = reflect.TypeOf(time.Time{})
 
-	num, err := strconv.Atoi(numStr)
+// setRFC3339 parses an RFC 3339 timestamp string into a time.Time. It
+// implements the "rfc3339" tag option.
+func setRFC3339(val reflect.Value, data any) error {
+	if val.Type() != timeType {
+		return fmt.Errorf("rfc3339 field must be time.Time, got %v", val.Type())
+	}
+	str, ok := data.(string)
+	if !ok {
+		return fmt.Errorf("cannot decode %s into rfc3339 field", valueKind(data))
+	}
+	t, err := time.Parse(time.RFC3339, str)
 	if err != nil {
-		return 0, fmt.Errorf("invalid integer: %s", numStr)
+		return fmt.Errorf("invalid RFC 3339 time %q", str)
 	}
-
-	return num, nil
+	val.Set(reflect.ValueOf(t))
+	return nil
 }
 
-func (d *Decoder) decodeList() ([]any, error) {
-	d.advance() // Skip over the 'l'
-	var result []any
+var tcpAddrSliceType = reflect.TypeOf([]net.TCPAddr(nil))
 
-	// Read values until we hit 'e'
-	for d.curToken < len(d.rawBytes) && d.curTokenIs() != end {
-		value, err := d.decode()
-		if err != nil {
-			return nil, err
-		}
-		result = append(result, value)
+// setCompactPeers6 fills a []net.TCPAddr or []Peer from a string of 18-byte
+// records, each a 16-byte IPv6 address followed by a big-endian port, as in
+// the "peers6" key of BEP 7. It implements the "compact6" tag option.
+func setCompactPeers6(val reflect.Value, data any) error {
+	if val.Type() != tcpAddrSliceType && val.Type() != peerSliceType {
+		return fmt.Errorf("compact6 field must be []net.TCPAddr or []Peer, got %v", val.Type())
 	}
-
-	if d.curToken >= len(d.rawBytes) {
-		return nil, fmt.Errorf("unexpected EOF while reading list")
+	str, ok := data.(string)
+	if !ok {
+		return fmt.Errorf("cannot decode %s into compact6 field", valueKind(data))
+	}
+	peers, err := parseCompactPeers(str, net.IPv6len)
+	if err != nil {
+		return err
 	}
 
-	d.advance() // Skip the 'e'
-	return result, nil
+	if val.Type() == peerSliceType {
+		val.Set(reflect.ValueOf(peers))
+		return nil
+	}
+	addrs := make([]net.TCPAddr, len(peers))
+	for i, p := range peers {
+		addrs[i] = net.TCPAddr{IP: p.IP, Port: int(p.Port)}
+	}
+	val.Set(reflect.ValueOf(addrs))
+	return nil
 }
 
-func (d *Decoder) decodeDict() (map[string]any, error) {
-	d.advance() // Skip over the 'd'
-	result := make(map[string]any)
-	for d.curToken < len(d.rawBytes) && d.curTokenIs() != end {
-		if !(d.curTokenIs() >= asciiZero && d.curTokenIs() <= asciiNine) {
-			return nil, fmt.Errorf("dictionary key must be a string")
-		}
-		key, err := d.decodeString() // Decode the key
-		if err != nil {
-			return nil, err
-		}
-		value, err := d.decode() // Decode the value
-		if err != nil {
-			return nil, err
+// indirect follows pointers from val, allocating nil ones, and returns the
+// value they ultimately point to.
+func indirect(val reflect.Value) reflect.Value {
+	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			val.Set(reflect.New(val.Type().Elem()))
 		}
+		val = val.Elem()
+	}
+	return val
+}
 
-		result[key] = value
+// setListMap fills an integer-keyed map from a list, keying each element by
+// its index. It implements the "listmap" tag option.
+func (d *Decoder) setListMap(val reflect.Value, data any) error {
+	list, ok := data.([]any)
+	if !ok {
+		return fmt.Errorf("cannot decode %s into listmap field", valueKind(data))
+	}
+	if val.Kind() != reflect.Map {
+		return fmt.Errorf("listmap field must be a map, got %v", val.Type())
+	}
+	switch val.Type().Key().Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		return fmt.Errorf("listmap field must have integer keys, got %v", val.Type())
 	}
 
-	if d.curToken >= len(d.rawBytes) {
-		return nil, fmt.Errorf("unexpected EOF while reading dictionary")
+	if val.IsNil() {
+		val.Set(reflect.MakeMapWithSize(val.Type(), len(list)))
 	}
+	for i, item := range list {
+		mapKey := reflect.New(val.Type().Key()).Elem()
+		if err := d.setReflectValue(mapKey, i); err != nil {
+			return err
+		}
 
-	d.advance() // skip the e
+		mapVal := reflect.New(val.Type().Elem()).Elem()
+		if err := d.setReflectValue(mapVal, item); err != nil {
+			if err := d.collect(fmt.Errorf("list index %d: %w", i, err)); err != nil {
+				return err
+			}
+			continue
+		}
 
-	return result, nil
+		val.SetMapIndex(mapKey, mapVal)
+	}
+
+	return nil
 }
 
-func (d *Decoder) decode() (any, error) {
-	if d.curToken >= len(d.rawBytes) {
-		return nil, io.EOF
+// setInfoHash fills a field tagged with the "infohash" option with the SHA1
+// of the raw top-level "info" dictionary. A [20]byte field receives the digest
+// itself and a string field receives its hex encoding. The field is left
+// untouched when the input has no top-level "info" key.
+func (d *Decoder) setInfoHash(val reflect.Value) error {
+	if d.infoEnd == 0 {
+		return nil
 	}
+	sum := sha1.Sum(d.rawBytes[d.infoStart:d.infoEnd])
 
-	curToken := d.curTokenIs()
 	switch {
-	case curToken == null:
-		return nil, nil
-	case curToken == integer:
-		return d.decodeInteger()
-	case curToken == lists:
-		return d.decodeList()
-	case curToken == dict:
-		return d.decodeDict()
-	case curToken >= asciiZero && curToken <= asciiNine:
-		return d.decodeString()
+	case val.Kind() == reflect.Array && val.Type().Elem().Kind() == reflect.Uint8 && val.Len() == len(sum):
+		reflect.Copy(val, reflect.ValueOf(sum[:]))
+	case val.Kind() == reflect.String:
+		val.SetString(hex.EncodeToString(sum[:]))
 	default:
-		return nil, fmt.Errorf("unknown token: %c", curToken)
+		return fmt.Errorf("infohash field must be [20]byte or string, got %v", val.Type())
 	}
+
+	return nil
 }
 
-func (d *Decoder) fillStruct(data any, val reflect.Value) error {
-	if val.Kind() == reflect.Ptr {
-		if val.IsNil() {
-			val.Set(reflect.New(val.Type().Elem()))
+// tagOptions is the string following the first comma in a struct field's
+// bencode tag.
+type tagOptions string
+
+// Contains reports whether a comma-separated list of options contains the
+// given option.
+func (o tagOptions) Contains(option string) bool {
+	for o != "" {
+		name, rest, _ := strings.Cut(string(o), ",")
+		if name == option {
+			return true
 		}
-		return d.fillStruct(data, val.Elem())
+		o = tagOptions(rest)
 	}
+	return false
+}
 
-	if dict, ok := data.(map[string]any); !ok {
-		return d.setReflectValue(val, data)
-	} else {
-		if val.Kind() != reflect.Struct {
-			return fmt.Errorf("cannot decode dictionary into non-struct type: %v", val.Type())
-		}
+// supportedKind reports whether values of type t, after following pointers,
+// have a kind setReflectValue can fill. It must list the same kinds as the
+// cases of setReflectValue's switch.
+func supportedKind(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64,
+		reflect.Slice, reflect.Map, reflect.Struct:
+		return true
+	case reflect.Interface:
+		return t.NumMethod() == 0
+	}
+	return false
+}
 
-		t := val.Type()
-		for i := 0; i < t.NumField(); i++ {
-			field := t.Field(i)
-			fieldVal := val.Field(i)
+// CanDecodeInto reports whether the decoder can fill values of type t,
+// including every element, map key and exported struct field reachable from
+// it. Use it to validate destination types at startup instead of discovering
+// unsupported fields while decoding. Type hooks registered on a Decoder are
+// not taken into account.
+func CanDecodeInto(t reflect.Type) bool {
+	return canDecodeInto(t, make(map[reflect.Type]bool))
+}
 
-			if !fieldVal.CanSet() {
-				continue // Skip unexported fields
-			}
+func canDecodeInto(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return true // Recursive types are checked once
+	}
+	seen[t] = true
 
-			tagName := parseTag(field)
-			if tagName == "-" {
-				continue // Skip fields tagged with "-"
-			}
+	if !supportedKind(t) {
+		return false
+	}
 
-			bencodeValue, exists := dict[tagName]
-			if !exists {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice:
+		return canDecodeInto(t.Elem(), seen)
+	case reflect.Map:
+		// Keys are filled from dictionary key strings.
+		switch t.Key().Kind() {
+		case reflect.Slice, reflect.Map, reflect.Struct, reflect.Ptr:
+			return false
+		}
+		return canDecodeInto(t.Key(), seen) && canDecodeInto(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
 				continue
 			}
-
-			if err := d.setReflectValue(fieldVal, bencodeValue); err != nil {
-				return err
+			name, opts := parseTag(field)
+			if name == "-" || opts.Contains("infohash") {
+				continue
+			}
+			if !canDecodeInto(field.Type, seen) {
+				return false
 			}
 		}
 	}
 
-	return nil
+	return true
+}
+
+// valueKind names the bencode type of a decoded value for error messages.
+func valueKind(data any) string {
+	switch data.(type) {
+	case int, Number:
+		return "integer"
+	case string, LazyString:
+		return "string"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "dictionary"
+	default:
+		return fmt.Sprintf("%T", data)
+	}
 }
 
-func parseTag(field reflect.StructField) string {
+// Get returns the value of a "name=value" option.
+func (o tagOptions) Get(name string) (string, bool) {
+	for o != "" {
+		opt, rest, _ := strings.Cut(string(o), ",")
+		if key, value, ok := strings.Cut(opt, "="); ok && key == name {
+			return value, true
+		}
+		o = tagOptions(rest)
+	}
+	return "", false
+}
+
+func parseTag(field reflect.StructField) (string, tagOptions) {
 	tag := field.Tag.Get("bencode")
 	if tag == "" {
-		return field.Name
+		return field.Name, ""
 	}
 
-	parts := strings.Split(tag, ",")
-	name := parts[0]
+	name, opts, _ := strings.Cut(tag, ",")
 	if name == "" {
 		name = field.Name
 	}
 
-	return name
+	return name, tagOptions(opts)
 }
 
 func (d *Decoder) setReflectValue(val reflect.Value, data any) error {
+	if fn, ok := d.typeHooks[val.Type()]; ok {
+		return d.applyTypeHook(val, data, fn)
+	}
+	if val.Kind() != reflect.Interface {
+		data = materialize(data)
+	}
+
+	if val.Type() == rawMessageType {
+		// Callers with access to the value's exact bytes set them directly;
+		// anything else, such as a list element, is re-encoded.
+		raw, err := appendValue(nil, data)
+		if err != nil {
+			return err
+		}
+		val.SetBytes(raw)
+		return nil
+	}
+
+	if val.Type() == rawIntType {
+		switch num := data.(type) {
+		case int:
+			val.SetBytes(strconv.AppendInt(nil, int64(num), 10))
+		case Number:
+			val.SetBytes([]byte(num))
+		default:
+			return fmt.Errorf("cannot decode %s into RawInt", valueKind(data))
+		}
+		return nil
+	}
+
 	switch val.Kind() {
 	case reflect.String:
-		if str, ok := data.(string); ok {
+		if val.Type() == numberType {
+			switch num := data.(type) {
+			case int:
+				val.SetString(strconv.Itoa(num))
+			case Number:
+				val.Set(reflect.ValueOf(num))
+			default:
+				return fmt.Errorf("cannot set Number with value of type %T", data)
+			}
+		} else if str, ok := data.(string); ok {
 			val.SetString(str)
 		} else {
 			return fmt.Errorf("cannot set string with value of type %T", data)
@@ -274,44 +2283,138 @@ func (d *Decoder) setReflectValue(val reflect.Value, data any) error {
 
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		if num, ok := data.(int); ok {
+			if val.OverflowInt(int64(num)) {
+				return fmt.Errorf("integer %d overflows %v", num, val.Type())
+			}
 			val.SetInt(int64(num))
 		} else if str, ok := data.(string); ok {
-			if num, err := strconv.ParseInt(str, 10, 64); err == nil {
+			if num, err := strconv.ParseInt(str, 10, val.Type().Bits()); err == nil {
 				val.SetInt(num)
 			} else {
 				return fmt.Errorf("cannot convert string to int: %v", err)
 			}
+		} else if num, ok := data.(Number); ok {
+			// Integers beyond the platform's int arrive as Number, yet may
+			// still fit a wider field, such as an int64 on 32-bit platforms.
+			n, err := strconv.ParseInt(string(num), 10, val.Type().Bits())
+			if err != nil {
+				return fmt.Errorf("integer %s overflows %v", num, val.Type())
+			}
+			val.SetInt(n)
 		} else {
 			return fmt.Errorf("cannot set int with value of type %T", data)
 		}
+		return d.checkEnum(val)
 
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 		if num, ok := data.(int); ok && num >= 0 {
+			if val.OverflowUint(uint64(num)) {
+				return fmt.Errorf("integer %d overflows %v", num, val.Type())
+			}
 			val.SetUint(uint64(num))
+		} else if str, ok := data.(string); ok {
+			// Numeric strings are accepted so that dictionary keys can fill
+			// integer-keyed maps.
+			if num, err := strconv.ParseUint(str, 10, val.Type().Bits()); err == nil {
+				val.SetUint(num)
+			} else {
+				return fmt.Errorf("cannot convert string to uint: %v", err)
+			}
+		} else if num, ok := data.(Number); ok {
+			n, err := strconv.ParseUint(string(num), 10, val.Type().Bits())
+			if err != nil {
+				return fmt.Errorf("integer %s overflows %v", num, val.Type())
+			}
+			val.SetUint(n)
 		} else {
 			return fmt.Errorf("cannot set uint with value of type %T", data)
 		}
+		return d.checkEnum(val)
 
 	case reflect.Bool:
+		// Booleans are normally encoded as i1e/i0e, but some dialects use the
+		// strings "true"/"false" or "1"/"0" instead; both forms are accepted.
 		if num, ok := data.(int); ok {
 			val.SetBool(num != 0)
+		} else if str, ok := data.(string); ok {
+			if b, ok := d.BoolStrings[str]; ok {
+				val.SetBool(b)
+				break
+			}
+			switch str {
+			case "true", "1":
+				val.SetBool(true)
+			case "false", "0":
+				val.SetBool(false)
+			default:
+				return fmt.Errorf("cannot convert string to bool: %q", str)
+			}
 		} else {
 			return fmt.Errorf("cannot set bool with value of type %T", data)
 		}
 
 	case reflect.Float32, reflect.Float64:
-		if num, ok := data.(int); ok {
-			val.SetFloat(float64(num))
+		var digits string
+		switch num := data.(type) {
+		case int:
+			digits = strconv.Itoa(num)
+		case Number:
+			digits = string(num)
+		}
+		if digits != "" {
+			f, err := strconv.ParseFloat(digits, val.Type().Bits())
+			if err != nil {
+				return fmt.Errorf("integer %s overflows %v", digits, val.Type())
+			}
+			if d.ExactFloats {
+				// Compare in big.Float, since neither side fits the other's
+				// native type for integers beyond int64 or floats beyond 2^63.
+				n, _ := new(big.Int).SetString(digits, 10)
+				if new(big.Float).SetFloat64(f).Cmp(new(big.Float).SetInt(n)) != 0 {
+					return fmt.Errorf("integer %s cannot be represented exactly as %v", digits, val.Type())
+				}
+			}
+			val.SetFloat(f)
+		} else if str, ok := data.(string); ok {
+			// Bencode has no float type, so fractional values are commonly
+			// stored as strings such as "3.14".
+			if num, err := strconv.ParseFloat(str, val.Type().Bits()); err == nil {
+				val.SetFloat(num)
+			} else {
+				return fmt.Errorf("cannot convert string to float: %v", err)
+			}
 		} else {
 			return fmt.Errorf("cannot set float with value of type %T", data)
 		}
 
 	case reflect.Slice:
-		if list, ok := data.([]any); ok {
-			newSlice := reflect.MakeSlice(val.Type(), len(list), len(list))
+		if str, ok := data.(string); ok && val.Type() == peerSliceType {
+			peers, err := parseCompactPeers(str, net.IPv4len)
+			if err != nil {
+				return err
+			}
+			val.Set(reflect.ValueOf(peers))
+		} else if list, ok := data.([]any); ok {
+			// Reuse the existing backing array when it is large enough, so
+			// decoding repeatedly into the same slice doesn't reallocate. A
+			// nil slice is replaced even for an empty list, which decodes to
+			// an empty slice rather than nil.
+			newSlice := val
+			if val.Cap() > 0 && val.Cap() >= len(list) {
+				newSlice.SetLen(len(list))
+			} else {
+				newSlice = reflect.MakeSlice(val.Type(), len(list), len(list))
+			}
 			for i, item := range list {
-				if err := d.setReflectValue(newSlice.Index(i), item); err != nil {
-					return err
+				elem := newSlice.Index(i)
+				elem.SetZero()
+				d.enterIndex(i)
+				err := d.setReflectValue(elem, item)
+				d.leavePath()
+				if err != nil {
+					if err := d.collect(fmt.Errorf("list index %d: %w", i, err)); err != nil {
+						return err
+					}
 				}
 			}
 			val.Set(newSlice)
@@ -327,6 +2430,11 @@ func (d *Decoder) setReflectValue(val reflect.Value, data any) error {
 				val.Set(reflect.MakeMap(val.Type()))
 			}
 
+			var raws map[string]RawMessage
+			if isRawType(val.Type().Elem()) {
+				raws = d.rawEntries(dict)
+			}
+
 			for k, v := range dict {
 				mapKey := reflect.New(val.Type().Key()).Elem()
 				if err := d.setReflectValue(mapKey, k); err != nil {
@@ -334,8 +2442,29 @@ func (d *Decoder) setReflectValue(val reflect.Value, data any) error {
 				}
 
 				mapVal := reflect.New(val.Type().Elem()).Elem()
-				if err := d.setReflectValue(mapVal, v); err != nil {
-					return err
+				if raw, ok := raws[k]; ok {
+					if err := setRaw(mapVal, raw); err != nil {
+						if err := d.collect(err); err != nil {
+							return err
+						}
+						continue
+					}
+				} else {
+					d.enterKey(k)
+					err := d.setReflectValue(mapVal, v)
+					d.leavePath()
+					if err != nil {
+						if err := d.collect(err); err != nil {
+							return err
+						}
+						continue
+					}
+				}
+				if err := d.setKeyField(mapVal, k); err != nil {
+					if err := d.collect(err); err != nil {
+						return err
+					}
+					continue
 				}
 
 				val.SetMapIndex(mapKey, mapVal)
@@ -345,16 +2474,26 @@ func (d *Decoder) setReflectValue(val reflect.Value, data any) error {
 		}
 
 	case reflect.Struct:
+		if val.Type() == tcpAddrType || val.Type() == udpAddrType {
+			return setAddr(val, data)
+		}
+		if val.Type() == peerType {
+			return setPeer(val, data)
+		}
 		if dict, ok := data.(map[string]any); ok {
-			nestedDecoder := Decoder{rawBytes: d.rawBytes, curToken: d.curToken}
-			return nestedDecoder.fillStruct(dict, val)
+			return d.fillStruct(dict, val)
 		} else {
-			return fmt.Errorf("cannot set struct with value of type %T", data)
+			return fmt.Errorf("cannot decode %s into struct %v", valueKind(data), val.Type())
 		}
 
 	case reflect.Interface:
-		if val.Type().NumMethod() == 0 {
-			val.Set(reflect.ValueOf(data))
+		if t, ok := d.registeredType(data); ok {
+			return d.setRegisteredType(val, t, data)
+		}
+		if data == nil {
+			val.SetZero()
+		} else if val.Type().NumMethod() == 0 {
+			val.Set(reflect.ValueOf(d.convertInts(data)))
 		} else {
 			return fmt.Errorf("cannot set non-empty interface with value of type %T", data)
 		}
@@ -366,7 +2505,7 @@ func (d *Decoder) setReflectValue(val reflect.Value, data any) error {
 		return d.setReflectValue(val.Elem(), data)
 
 	default:
-		return fmt.Errorf("unsupported type: %v", val.Type())
+		return fmt.Errorf("unsupported type: %v values cannot be decoded from bencode", val.Type())
 	}
 
 	return nil
@@ -0,0 +1,1272 @@
+package bencode
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDecodeFramed(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		want    any
+		wantErr error // nil for success, errAny for any error
+	}{
+		{"integer", []byte("\x00\x00\x00\x04i42e"), 42, nil},
+		{"trailing bytes unread", []byte("\x00\x00\x00\x04i42ei7e"), 42, nil},
+		{"empty frame", []byte("\x00\x00\x00\x00"), nil, errAny},
+		{"short prefix", []byte("\x00\x00"), nil, io.ErrUnexpectedEOF},
+		{"short frame", []byte("\x00\x00\x00\x05i42e"), nil, io.ErrUnexpectedEOF},
+		{"NUL frame", []byte("\x00\x00\x00\x01\x00"), nil, errAny},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got any
+			err := DecodeFramed(bytes.NewReader(tt.input), &got)
+			if !errorMatches(err, tt.wantErr) {
+				t.Fatalf("DecodeFramed() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("DecodeFramed() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncatedInputErrors(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantMsg string
+		offset  int
+	}{
+		{"d3:foo", `dictionary starting at offset 0: unexpected EOF: missing value for dictionary key "foo"`, 6},
+		{"d3:fooi1e", "dictionary starting at offset 0: unexpected EOF: dictionary is missing its closing 'e'", 9},
+		{"li1el", "list starting at offset 4: unexpected EOF: list is missing its closing 'e'", 5},
+		{"li12", "list starting at offset 0: unexpected EOF while reading integer", 4},
+		{"i12", "unexpected EOF while reading integer", 3},
+		{"5:ab", "unexpected EOF: string is shorter than its length 5", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := Decode[any]([]byte(tt.input))
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("Decode() error = %v, want a SyntaxError", err)
+			}
+			if !strings.Contains(err.Error(), tt.wantMsg) || syntaxErr.Offset != tt.offset {
+				t.Errorf("Decode() error = %q, want %q at offset %d", err, tt.wantMsg, tt.offset)
+			}
+		})
+	}
+}
+
+type testCircle struct {
+	Kind   string `bencode:"kind"`
+	Radius int    `bencode:"radius"`
+}
+
+func TestRegisterType(t *testing.T) {
+	input := []byte("d4:kind6:circle6:radiusi3ee")
+	tests := []struct {
+		name string
+		typ  reflect.Type
+		want any
+	}{
+		{"struct", reflect.TypeOf(testCircle{}), testCircle{Kind: "circle", Radius: 3}},
+		{"interface ignored", reflect.TypeOf((*any)(nil)).Elem(), map[string]any{"kind": "circle", "radius": 3}},
+		{"pointer to interface ignored", reflect.TypeOf((*any)(nil)), map[string]any{"kind": "circle", "radius": 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newBytesDecoder(input)
+			d.SetDiscriminatorKey("kind")
+			d.RegisterType("circle", tt.typ)
+			var got any
+			if err := d.Decode(&got); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeLongInteger(t *testing.T) {
+	// Digits used to be gathered one string concatenation at a time, which
+	// took quadratic time on inputs like this once the digit limit was off.
+	digits := strings.Repeat("9", 1<<20)
+	d := newBytesDecoder([]byte("i" + digits + "e"))
+	d.MaxIntDigits = 0
+	var got Number
+	if err := d.Decode(&got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if string(got) != digits {
+		t.Errorf("Decode() returned %d digits, want %d", len(got), len(digits))
+	}
+}
+
+func TestPathSyntax(t *testing.T) {
+	type file struct {
+		Length int `bencode:"length"`
+	}
+	type torrent struct {
+		Info struct {
+			Files []file `bencode:"files"`
+		} `bencode:"info"`
+		Trackers map[string]file `bencode:"trackers"`
+	}
+	input := []byte("d4:infod5:filesld6:lengthi1eeee8:trackersd1:td6:lengthi2eeee")
+
+	d := newBytesDecoder(input)
+	d.RecordKeyPositions = true
+	var v torrent
+	present, err := d.DecodeWithPresence(&v)
+	if err != nil {
+		t.Fatalf("DecodeWithPresence() error = %v", err)
+	}
+
+	// Map keys have positions but are not struct fields, so only
+	// KeyPositions reports "trackers.t".
+	wantPositions := map[string]int{
+		"info":                 1,
+		"info.files":           8,
+		"info.files[0].length": 17,
+		"trackers":             31,
+		"trackers.t":           42,
+		"trackers.t.length":    46,
+	}
+	wantPresent := map[string]bool{
+		"info":                 true,
+		"info.files":           true,
+		"info.files[0].length": true,
+		"trackers":             true,
+		"trackers.t.length":    true,
+	}
+	if !reflect.DeepEqual(d.KeyPositions(), wantPositions) {
+		t.Errorf("KeyPositions() = %v, want %v", d.KeyPositions(), wantPositions)
+	}
+	if !reflect.DeepEqual(present, wantPresent) {
+		t.Errorf("DecodeWithPresence() = %v, want %v", present, wantPresent)
+	}
+}
+
+func TestSkipMaxBytes(t *testing.T) {
+	tests := []struct {
+		input    string
+		maxBytes int
+		wantErr  error
+	}{
+		{"l5:helloe", 3, ErrInputTooLarge},
+		{"l5:helloe", 8, ErrInputTooLarge},
+		{"l5:helloe", 9, nil},
+		{"i12345e", 4, ErrInputTooLarge},
+		{"d1:ai1ee", 2, ErrInputTooLarge},
+		{"d1:ai1ee", 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%d", tt.input, tt.maxBytes), func(t *testing.T) {
+			d := newBytesDecoder([]byte(tt.input))
+			d.MaxBytes = tt.maxBytes
+			if err := d.Skip(); !errorMatches(err, tt.wantErr) {
+				t.Errorf("Skip() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStrictIntegersAndLengths(t *testing.T) {
+	tests := []struct {
+		input   string
+		lenient any // Value with AcceptLeadingZeros; strict decoding fails
+	}{
+		{"i03e", 3},
+		{"i-03e", -3},
+		{"i-0e", 0},
+		{"i00e", 0},
+		{"05:hello", "hello"},
+		{"00:", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			d := newBytesDecoder([]byte(tt.input))
+			if _, err := d.decodeAll(); err == nil {
+				t.Errorf("strict decode succeeded, want an error")
+			}
+			d = newBytesDecoder([]byte(tt.input))
+			if err := d.Skip(); err == nil {
+				t.Errorf("strict Skip succeeded, want an error")
+			}
+			var scanErr error
+			if tt.input[0] == integer {
+				_, _, scanErr = ScanInt([]byte(tt.input))
+			} else {
+				_, _, scanErr = ScanString([]byte(tt.input))
+			}
+			if scanErr == nil {
+				t.Errorf("scanning succeeded, want an error")
+			}
+
+			d = newBytesDecoder([]byte(tt.input))
+			d.AcceptLeadingZeros = true
+			var got any
+			if err := d.Decode(&got); err != nil || got != tt.lenient {
+				t.Errorf("lenient Decode() = %#v, %v, want %#v", got, err, tt.lenient)
+			}
+		})
+	}
+}
+
+func TestCanonicalIntegersAndLengths(t *testing.T) {
+	tests := []struct {
+		input string
+		want  any
+	}{
+		{"i0e", 0},
+		{"i-7e", -7},
+		{"i10e", 10},
+		{"0:", ""},
+		{"10:0123456789", "0123456789"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := Decode[any]([]byte(tt.input))
+			if err != nil || got != tt.want {
+				t.Errorf("Decode() = %#v, %v, want %#v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeSliceReuse(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		initial  []int
+		want     []int
+		wantSame bool // Whether the initial backing array is reused
+	}{
+		{"empty list into nil", "le", nil, []int{}, false},
+		{"empty list into empty", "le", make([]int, 0, 4), []int{}, true},
+		{"fits capacity", "li1ei2ee", make([]int, 5, 8), []int{1, 2}, true},
+		{"exceeds capacity", "li1ei2ei3ee", make([]int, 1, 2), []int{1, 2, 3}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.initial
+			d := newBytesDecoder([]byte(tt.input))
+			if err := d.Decode(&got); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if got == nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %#v, want %#v", got, tt.want)
+			}
+			same := cap(tt.initial) > 0 && &got[:1][0] == &tt.initial[:1][0]
+			if same != tt.wantSame {
+				t.Errorf("backing array reused = %v, want %v", same, tt.wantSame)
+			}
+		})
+	}
+}
+
+func TestDecodeInterfaceFields(t *testing.T) {
+	type fields struct {
+		Int  any `bencode:"int"`
+		Big  any `bencode:"big"`
+		Str  any `bencode:"str"`
+		List any `bencode:"list"`
+		Dict any `bencode:"dict"`
+	}
+	input := []byte("d3:bigi9223372036854775808e4:dictd1:ki2ee3:inti-7e4:listli1e1:xe3:str3:abce")
+	bigNum, _ := new(big.Int).SetString("9223372036854775808", 10)
+	tests := []struct {
+		name  string
+		setup func(*Decoder)
+		want  fields
+	}{
+		{"default", func(*Decoder) {}, fields{
+			Int:  -7,
+			Big:  Number("9223372036854775808"),
+			Str:  "abc",
+			List: []any{1, "x"},
+			Dict: map[string]any{"k": 2},
+		}},
+		{"LazyStrings", func(d *Decoder) { d.LazyStrings = true }, fields{
+			Int:  -7,
+			Big:  Number("9223372036854775808"),
+			Str:  LazyString{[]byte("abc")},
+			List: []any{1, LazyString{[]byte("x")}},
+			Dict: map[string]any{"k": 2},
+		}},
+		{"IntTypeInt64", func(d *Decoder) { d.IntType = IntTypeInt64 }, fields{
+			Int:  int64(-7),
+			Big:  Number("9223372036854775808"),
+			Str:  "abc",
+			List: []any{int64(1), "x"},
+			Dict: map[string]any{"k": int64(2)},
+		}},
+		{"IntTypeBigInt", func(d *Decoder) { d.IntType = IntTypeBigInt }, fields{
+			Int:  big.NewInt(-7),
+			Big:  bigNum,
+			Str:  "abc",
+			List: []any{big.NewInt(1), "x"},
+			Dict: map[string]any{"k": big.NewInt(2)},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newBytesDecoder(input)
+			tt.setup(&d)
+			var got fields
+			if err := d.Decode(&got); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemainingIntType(t *testing.T) {
+	tests := []struct {
+		intType IntType
+		want    []any
+	}{
+		{IntTypeInt, []any{2, []any{3}}},
+		{IntTypeInt64, []any{int64(2), []any{int64(3)}}},
+		{IntTypeBigInt, []any{big.NewInt(2), []any{big.NewInt(3)}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.intType), func(t *testing.T) {
+			d := newBytesDecoder([]byte("i1ei2eli3ee"))
+			d.KeepTrailing = true
+			d.IntType = tt.intType
+			var first int
+			if err := d.Decode(&first); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if first != 1 {
+				t.Errorf("Decode() = %d, want 1", first)
+			}
+			if got := d.Remaining(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Remaining() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodePointerToPointer(t *testing.T) {
+	type inner struct {
+		Name string `bencode:"name"`
+	}
+	var v struct {
+		Count **int   `bencode:"count"`
+		Inner **inner `bencode:"inner"`
+	}
+	d := newBytesDecoder([]byte("d5:counti7e5:innerd4:name1:xee"))
+	if err := d.Decode(&v); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	if v.Count == nil || *v.Count == nil || **v.Count != 7 {
+		t.Errorf("Count not decoded through both pointers")
+	}
+	if v.Inner == nil || *v.Inner == nil || (**v.Inner).Name != "x" {
+		t.Errorf("Inner not decoded through both pointers")
+	}
+}
+
+type testNode struct {
+	Size     int                 `bencode:"size"`
+	Children map[string]testNode `bencode:"children"`
+}
+
+func TestDecodeSelfReferentialType(t *testing.T) {
+	input := []byte("d8:childrend1:ad8:childrend1:bd4:sizei2eee4:sizei1ee1:cd4:sizei3eee4:sizei0ee")
+	want := testNode{Children: map[string]testNode{
+		"a": {Size: 1, Children: map[string]testNode{"b": {Size: 2}}},
+		"c": {Size: 3},
+	}}
+
+	got, err := Decode[testNode](input)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeTopLevelScalar(t *testing.T) {
+	tests := []struct {
+		input string
+		dest  any // Pointer to a zero value of the destination type
+		want  any
+	}{
+		{"i42e", new(int), 42},
+		{"i-42e", new(int64), int64(-42)},
+		{"i42e", new(uint8), uint8(42)},
+		{"4:spam", new(string), "spam"},
+		{"4:spam", new([]byte), []byte("spam")},
+		{"0:", new(string), ""},
+		{"i42e", new(any), 42},
+		{"4:spam", new(any), "spam"},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s into %T", tt.input, tt.dest), func(t *testing.T) {
+			d := newBytesDecoder([]byte(tt.input))
+			if err := d.Decode(tt.dest); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if got := reflect.ValueOf(tt.dest).Elem().Interface(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeTopLevelList(t *testing.T) {
+	tests := []struct {
+		input   string
+		dest    any
+		want    any
+		wantErr bool
+	}{
+		{"l1:a2:bce", new([]string), []string{"a", "bc"}, false},
+		{"li1ei-2ee", new([]int), []int{1, -2}, false},
+		{"le", new([]string), []string{}, false},
+		{"lli1eeli2ei3eee", new([][]int), [][]int{{1}, {2, 3}}, false},
+		{"l1:ae", new([]int), nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			d := newBytesDecoder([]byte(tt.input))
+			err := d.Decode(tt.dest)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := reflect.ValueOf(tt.dest).Elem().Interface(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeMapOfStructPointers(t *testing.T) {
+	type file struct {
+		Length int `bencode:"length"`
+	}
+
+	var files map[string]*file
+	d := newBytesDecoder([]byte("d1:ad6:lengthi1ee1:bd6:lengthi2eee"))
+	if err := d.Decode(&files); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	wantFiles := map[string]*file{"a": {Length: 1}, "b": {Length: 2}}
+	if !reflect.DeepEqual(files, wantFiles) {
+		t.Errorf("Decode() = %v, want %v", files, wantFiles)
+	}
+
+	var peers map[string]*Peer
+	d = newBytesDecoder([]byte("d1:ad2:ip8:10.0.0.14:porti6881eee"))
+	if err := d.Decode(&peers); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if p := peers["a"]; p == nil || !p.IP.Equal(net.IPv4(10, 0, 0, 1)) || p.Port != 6881 {
+		t.Errorf("Decode() = %v, want peer 10.0.0.1:6881", peers)
+	}
+}
+
+func TestPlusSignErrors(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantMsg string
+		offset  int
+	}{
+		{"i+5e", "invalid character in integer: + at offset 1", 1},
+		{"i+e", "invalid character in integer: + at offset 1", 1},
+		{"+", "unknown token: + at offset 0", 0},
+		{"li-+1ee", "invalid character in integer: + at offset 3", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := Decode[any]([]byte(tt.input))
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("Decode() error = %v, want a SyntaxError", err)
+			}
+			if err.Error() != tt.wantMsg || syntaxErr.Offset != tt.offset {
+				t.Errorf("Decode() error = %q at offset %d, want %q at offset %d", err, syntaxErr.Offset, tt.wantMsg, tt.offset)
+			}
+		})
+	}
+}
+
+func TestDecodeUnusualKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+	}{
+		{"colon", "a:b"},
+		{"digits and colon", "3:x"},
+		{"NUL byte", "a\x00b"},
+		{"non-UTF-8", "\xff\xfe"},
+		{"empty", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := encodeTest(t, map[string]any{tt.key: 1, "other": 2})
+			got, err := Decode[map[string]int](input)
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if want := map[string]int{tt.key: 1, "other": 2}; !reflect.DeepEqual(got, want) {
+				t.Errorf("Decode() = %q, want %q", got, want)
+			}
+		})
+	}
+
+	var v struct {
+		Colon   int `bencode:"a:b"`
+		NUL     int `bencode:"a\x00b"`
+		NonUTF8 int `bencode:"\xff\xfe"`
+	}
+	input := encodeTest(t, map[string]any{"a:b": 1, "a\x00b": 2, "\xff\xfe": 3})
+	d := newBytesDecoder(input)
+	if err := d.Decode(&v); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if v.Colon != 1 || v.NUL != 2 || v.NonUTF8 != 3 {
+		t.Errorf("Decode() = %+v, want {1 2 3}", v)
+	}
+}
+
+type (
+	testStringList []string
+	testByteString []byte
+	testCounts     map[string]int
+	testKey        string
+	testKeyedMap   map[testKey]testStringList
+)
+
+func TestDecodeNamedSliceAndMapTypes(t *testing.T) {
+	type named struct {
+		List   testStringList `bencode:"list"`
+		Bytes  testByteString `bencode:"bytes"`
+		Counts testCounts     `bencode:"counts"`
+		Keyed  testKeyedMap   `bencode:"keyed"`
+	}
+	input := []byte("d5:bytes3:abc6:countsd1:ai1ee5:keyedd1:kl1:xee4:listl1:a1:bee")
+	want := named{
+		List:   testStringList{"a", "b"},
+		Bytes:  testByteString("abc"),
+		Counts: testCounts{"a": 1},
+		Keyed:  testKeyedMap{"k": {"x"}},
+	}
+
+	got, err := Decode[named](input)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode() = %#v, want %#v", got, want)
+	}
+}
+
+func TestDecodeNestedMapsOfStructs(t *testing.T) {
+	type piece struct {
+		Hash RawMessage `bencode:"hash"`
+		Size int        `bencode:"size"`
+	}
+	type file struct {
+		Pieces map[string]piece `bencode:"pieces"`
+	}
+	type torrent struct {
+		Name  string           `bencode:"name"`
+		Files map[string]*file `bencode:"files"`
+	}
+
+	input := []byte("d5:filesd1:ad6:piecesd1:0d4:hash2:h04:sizei1ee1:1d4:hash2:h14:sizei2eeee" +
+		"1:bd6:piecesd1:0d4:hashli1ei2ee4:sizei3eeeee4:name1:te")
+	want := map[string]torrent{"t": {
+		Name: "t",
+		Files: map[string]*file{
+			"a": {Pieces: map[string]piece{
+				"0": {Hash: RawMessage("2:h0"), Size: 1},
+				"1": {Hash: RawMessage("2:h1"), Size: 2},
+			}},
+			"b": {Pieces: map[string]piece{
+				"0": {Hash: RawMessage("li1ei2ee"), Size: 3},
+			}},
+		},
+	}}
+
+	var got map[string]torrent
+	d := newBytesDecoder([]byte("d1:t" + string(input) + "e"))
+	if err := d.Decode(&got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeNumber(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Number
+	}{
+		{"i42e", "42"},
+		{"i9223372036854775807e", "9223372036854775807"},
+		{"i9223372036854775808e", "9223372036854775808"},
+		{"i-9223372036854775809e", "-9223372036854775809"},
+		{"i123456789012345678901234567890e", "123456789012345678901234567890"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			d := newBytesDecoder([]byte(tt.input))
+			d.MaxIntDigits = 0
+			var got Number
+			if err := d.Decode(&got); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Decode() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// Interface destinations receive a Number only when an int overflows.
+	got, err := Decode[any]([]byte("li1ei9223372036854775808ee"))
+	if want := []any{1, Number("9223372036854775808")}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode() = %#v, %v, want %#v", got, err, want)
+	}
+
+	// The default digit limit covers int64 but not beyond.
+	if _, err := Decode[Number]([]byte("i12345678901234567890e")); err == nil {
+		t.Errorf("Decode() of 20 digits succeeded with the default MaxIntDigits")
+	}
+}
+
+func TestDecodeFloat(t *testing.T) {
+	tests := []struct {
+		input  string
+		exact  bool
+		want64 any // float64 result, or errAny
+		want32 any // float32 result, or errAny
+	}{
+		{"i3e", false, 3.0, float32(3)},
+		{"i-3e", true, -3.0, float32(-3)},
+		{"3:1.5", false, 1.5, float32(1.5)},
+		{"i9007199254740993e", false, 9007199254740992.0, float32(9007199254740992)},
+		{"i9007199254740993e", true, errAny, errAny},
+		{"i16777217e", true, 16777217.0, errAny},
+		{"i9223372036854775807e", false, 9223372036854775807.0, float32(9223372036854775807)},
+		{"i9223372036854775807e", true, errAny, errAny},
+		{"i-9223372036854775808e", true, -9223372036854775808.0, float32(-9223372036854775808)},
+		// Beyond int64, the integer arrives as a Number.
+		{"i9999999999999999999e", false, 9999999999999999999.0, float32(9999999999999999999)},
+		{"i9999999999999999999e", true, errAny, errAny},
+		{"i18446744073709551616e", true, 18446744073709551616.0, float32(18446744073709551616)},
+		{"i-18446744073709551617e", true, errAny, errAny},
+		{"i1" + strings.Repeat("0", 39) + "e", false, 1e39, errAny},
+		{"i1" + strings.Repeat("0", 309) + "e", false, errAny, errAny},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%.30s/exact=%v", tt.input, tt.exact), func(t *testing.T) {
+			decode := func(v any) error {
+				d := newBytesDecoder([]byte(tt.input))
+				d.MaxIntDigits = 0
+				d.ExactFloats = tt.exact
+				return d.Decode(v)
+			}
+
+			var got64 float64
+			if err := decode(&got64); tt.want64 == errAny {
+				if err == nil {
+					t.Errorf("Decode() into float64 = %v, want an error", got64)
+				}
+			} else if err != nil || got64 != tt.want64 {
+				t.Errorf("Decode() into float64 = %v, %v, want %v", got64, err, tt.want64)
+			}
+
+			var got32 float32
+			if err := decode(&got32); tt.want32 == errAny {
+				if err == nil {
+					t.Errorf("Decode() into float32 = %v, want an error", got32)
+				}
+			} else if err != nil || got32 != tt.want32 {
+				t.Errorf("Decode() into float32 = %v, %v, want %v", got32, err, tt.want32)
+			}
+		})
+	}
+}
+
+func TestSkip(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantEnd int // Offset after the skipped value
+		wantErr bool
+	}{
+		{"i42ei1e", 4, false},
+		{"4:spami1e", 6, false},
+		{"li1el1:ad1:bleeeei1e", 17, false},
+		{"d1:ad1:bli1eee1:cleei1e", 20, false},
+		{"dei1e", 2, false},
+		{"d1:ali1ee", 0, true},
+		{"di1ei2ee", 0, true},
+		{"lx", 0, true},
+		{"9223372036854775807:a", 0, true},
+		{"l9223372036854775807:ae", 0, true},
+		{"d1:a9223372036854775807:ae", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			d := newBytesDecoder([]byte(tt.input))
+			err := d.Skip()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Skip() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if d.curToken != tt.wantEnd {
+				t.Errorf("Skip() stopped at offset %d, want %d", d.curToken, tt.wantEnd)
+			}
+			if got, err := d.decode(); err != nil || got != 1 {
+				t.Errorf("value after skipped one = %v, %v, want 1", got, err)
+			}
+		})
+	}
+}
+
+type testInner struct{ Name string }
+
+type testEmbedded struct{ Name string }
+
+type testUnexportedFields struct {
+	testInner
+	*testEmbedded
+	hidden int
+	Shown  int
+	Nested map[string]struct {
+		testInner
+		secret string
+		Size   int
+	}
+}
+
+func TestDecodeUnexportedAndEmbeddedFields(t *testing.T) {
+	// Unexported fields, including embedded structs of unexported type and
+	// the fields they promote, are left untouched rather than panicking.
+	input := []byte("d4:Name1:x6:Nestedd1:ad4:Name1:z4:Sizei3e6:secret1:see5:Showni2e" +
+		"6:hiddeni1e12:testEmbeddedd4:Name1:ye9:testInner4:nonee")
+	want := testUnexportedFields{
+		Shown: 2,
+		Nested: map[string]struct {
+			testInner
+			secret string
+			Size   int
+		}{"a": {Size: 3}},
+	}
+
+	got, err := Decode[testUnexportedFields](input)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeTypedMapKeys(t *testing.T) {
+	tests := []struct {
+		input   string
+		dest    any
+		want    any
+		wantErr bool
+	}{
+		{"d1:13:one2:-23:twoe", new(map[int]string), map[int]string{1: "one", -2: "two"}, false},
+		{"d1:75:sevene", new(map[uint8]string), map[uint8]string{7: "seven"}, false},
+		{"d1:ai1ee", new(map[testKey]int), map[testKey]int{"a": 1}, false},
+		{"d1:xi1ee", new(map[int]int), nil, true},
+		{"d3:256i1ee", new(map[uint8]int), nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			d := newBytesDecoder([]byte(tt.input))
+			err := d.Decode(tt.dest)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := reflect.ValueOf(tt.dest).Elem().Interface(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeBigIntOption(t *testing.T) {
+	id := []byte("\x80\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10\x11\x12\xff")
+	want, _ := new(big.Int).SetString("800102030405060708090a0b0c0d0e0f101112ff", 16)
+
+	var v struct {
+		NodeID *big.Int `bencode:"node_id,bigint"`
+		Value  big.Int  `bencode:"value,bigint"`
+	}
+	input := encodeTest(t, map[string]any{"node_id": string(id), "value": "\x01\x00"})
+	d := newBytesDecoder(input)
+	if err := d.Decode(&v); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if v.NodeID == nil || v.NodeID.Cmp(want) != 0 {
+		t.Errorf("NodeID = %v, want %v", v.NodeID, want)
+	}
+	if v.Value.Int64() != 256 {
+		t.Errorf("Value = %v, want 256", &v.Value)
+	}
+
+	var bad struct {
+		NodeID *big.Int `bencode:"node_id,bigint"`
+	}
+	d = newBytesDecoder([]byte("d7:node_idi5ee"))
+	if err := d.Decode(&bad); err == nil {
+		t.Errorf("Decode() of an integer into a bigint field succeeded")
+	}
+}
+
+func TestDecodeInto(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    map[string]any
+		wantErr bool
+	}{
+		{"merge", "d1:bi20e1:ci3ee", map[string]any{"a": 1, "b": 20, "c": 3, "nested": map[string]any{"x": 1}}, false},
+		{"replace nested", "d6:nestedd1:yi2eee", map[string]any{"a": 1, "b": 2, "nested": map[string]any{"y": 2}}, false},
+		{"empty dictionary", "de", map[string]any{"a": 1, "b": 2, "nested": map[string]any{"x": 1}}, false},
+		{"not a dictionary", "li1ee", nil, true},
+		{"several values", "dedee", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := map[string]any{"a": 1, "b": 2, "nested": map[string]any{"x": 1}}
+			d := newBytesDecoder([]byte(tt.input))
+			err := d.DecodeInto(m)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeInto() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(m, tt.want) {
+				t.Errorf("DecodeInto() left %v, want %v", m, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeIntegerOverflow(t *testing.T) {
+	tests := []struct {
+		input   string
+		dest    any
+		want    any
+		wantErr bool
+	}{
+		{"i2147483647e", new(int32), int32(math.MaxInt32), false},
+		{"i-2147483648e", new(int32), int32(math.MinInt32), false},
+		{"i2147483648e", new(int32), nil, true},
+		{"i-2147483649e", new(int32), nil, true},
+		{"i127e", new(int8), int8(127), false},
+		{"i128e", new(int8), nil, true},
+		{"i65535e", new(uint16), uint16(65535), false},
+		{"i65536e", new(uint16), nil, true},
+		{"i-1e", new(uint32), nil, true},
+		{"i9223372036854775807e", new(int64), int64(math.MaxInt64), false},
+		{"i9223372036854775808e", new(int64), nil, true},
+		{"i18446744073709551615e", new(uint64), uint64(math.MaxUint64), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s into %T", tt.input, tt.dest), func(t *testing.T) {
+			d := newBytesDecoder([]byte(tt.input))
+			d.MaxIntDigits = 0
+			err := d.Decode(tt.dest)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := reflect.ValueOf(tt.dest).Elem().Interface(); got != tt.want {
+				t.Errorf("Decode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// largeTorrent returns a multi-file torrent the size of a typical season
+// pack: 2000 files in nested directories and 8000 pieces.
+func largeTorrent(b *testing.B) []byte {
+	files := make([]any, 2000)
+	for i := range files {
+		files[i] = map[string]any{
+			"length": 1<<20 + i,
+			"path":   []any{fmt.Sprintf("disc %d", i/100), "extras", fmt.Sprintf("file %04d.mkv", i)},
+		}
+	}
+	return encodeTest(b, map[string]any{
+		"announce":      "http://tracker.example.com/announce",
+		"announce-list": []any{[]any{"http://tracker.example.com/announce"}, []any{"udp://tracker.example.org:6969"}},
+		"comment":       "benchmark fixture",
+		"created by":    "bencode",
+		"creation date": 1700000000,
+		"info": map[string]any{
+			"name":         "pack",
+			"piece length": 1 << 18,
+			"pieces":       strings.Repeat("\x01", 20*8000),
+			"files":        files,
+		},
+	})
+}
+
+func BenchmarkParseTorrent(b *testing.B) {
+	data := largeTorrent(b)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := ParseTorrent(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeInterface(b *testing.B) {
+	data := largeTorrent(b)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := Decode[any](data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeLazyStrings(b *testing.B) {
+	data := largeTorrent(b)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for b.Loop() {
+		d := newBytesDecoder(data)
+		d.LazyStrings = true
+		var v any
+		if err := d.Decode(&v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSkip(b *testing.B) {
+	data := largeTorrent(b)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for b.Loop() {
+		d := newBytesDecoder(data)
+		if err := d.Skip(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestMaxKeyLen(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    any
+		wantErr string
+	}{
+		{"d3:abci1ee", map[string]any{"abc": 1}, ""},
+		{"d4:abcdi1ee", nil, "dictionary key length 4 exceeds limit of 3"},
+		{"d1:ad4:abcdi1eee", nil, "dictionary key length 4 exceeds limit of 3"},
+		{"d4:abc", nil, "unexpected EOF"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			d := newBytesDecoder([]byte(tt.input))
+			d.MaxKeyLen = 3
+			var got any
+			err := d.Decode(&got)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Decode() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+
+	// An oversized key is rejected before it is copied out of the input.
+	const keyLen = 16 << 20
+	input := []byte(fmt.Sprintf("d%d:%si1ee", keyLen, strings.Repeat("k", keyLen)))
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	d := newBytesDecoder(input)
+	d.MaxKeyLen = 64
+	var got any
+	err := d.Decode(&got)
+	runtime.ReadMemStats(&after)
+	if err == nil {
+		t.Fatalf("Decode() of a %d-byte key succeeded with MaxKeyLen 64", keyLen)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated >= keyLen {
+		t.Errorf("Decode() allocated %d bytes rejecting a %d-byte key", allocated, keyLen)
+	}
+}
+
+func TestDecodeStats(t *testing.T) {
+	type withRaw struct {
+		N   int        `bencode:"n"`
+		Raw RawMessage `bencode:"raw"`
+	}
+	tests := []struct {
+		name  string
+		input string
+		run   func(*Decoder) error
+		want  DecodeStats
+	}{
+		{
+			name:  "keys are not strings",
+			input: "d1:ai1e1:bl1:xi2eee",
+			run:   func(d *Decoder) error { var v any; return d.Decode(&v) },
+			want:  DecodeStats{Strings: 1, Integers: 2, Lists: 1, Dicts: 1, Bytes: 19},
+		},
+		{
+			// The list wrapping several top-level values is not in the input.
+			name:  "top-level values",
+			input: "i1e2:abi3e",
+			run:   func(d *Decoder) error { var v []any; return d.Decode(&v) },
+			want:  DecodeStats{Strings: 1, Integers: 2, Bytes: 10},
+		},
+		{
+			name:  "skipped values",
+			input: "d1:ali1eeei5e",
+			run: func(d *Decoder) error {
+				if err := d.Skip(); err != nil {
+					return err
+				}
+				var n int
+				return d.Decode(&n)
+			},
+			want: DecodeStats{Integers: 1, Bytes: 13},
+		},
+		{
+			name:  "rescanned values",
+			input: "d1:ni1e3:rawli2ei3eee",
+			run: func(d *Decoder) error {
+				var v withRaw
+				if err := d.Decode(&v); err != nil {
+					return err
+				}
+				_, err := d.InfoHashV2("raw")
+				return err
+			},
+			want: DecodeStats{Integers: 3, Lists: 1, Dicts: 1, Bytes: 21},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newBytesDecoder([]byte(tt.input))
+			d.CollectStats = true
+			if err := tt.run(&d); err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if got := d.Stats(); got != tt.want {
+				t.Errorf("Stats() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// Nothing is counted unless CollectStats is set.
+	d := newBytesDecoder([]byte("li1e1:ae"))
+	var v any
+	if err := d.Decode(&v); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if got, want := d.Stats(), (DecodeStats{Bytes: 8}); got != want {
+		t.Errorf("Stats() without CollectStats = %+v, want %+v", got, want)
+	}
+}
+
+func TestHugeStringLength(t *testing.T) {
+	// Lengths near the int limit used to overflow the bounds check and
+	// panic instead of failing.
+	inputs := []string{
+		"9223372036854775807:a",
+		"l9223372036854775807:ae",
+		"d1:a9223372036854775807:ae",
+		"9223372036854775808:a", // Beyond int; rejected by the length parser
+	}
+	entryPoints := []struct {
+		name string
+		fn   func([]byte) error
+	}{
+		{"Decode", func(data []byte) error { _, err := Decode[any](data); return err }},
+		{"Decode with MaxBytes", func(data []byte) error {
+			d := newBytesDecoder(data)
+			d.MaxBytes = 1 << 20
+			var v any
+			return d.Decode(&v)
+		}},
+		{"IsCanonical", func(data []byte) error { _, err := IsCanonical(data); return err }},
+		{"ToJSON", func(data []byte) error { _, err := ToJSON(data); return err }},
+		{"UnmarshalStream", func(data []byte) error { return UnmarshalStream(data, func(any) error { return nil }) }},
+		{"ParseTorrent", func(data []byte) error { _, err := ParseTorrent(data); return err }},
+		{"MinimalReproducer", func(data []byte) error {
+			if !failsToDecode(MinimalReproducer(data)) {
+				return nil
+			}
+			return errAny
+		}},
+	}
+
+	for _, input := range inputs {
+		for _, ep := range entryPoints {
+			t.Run(ep.name+"/"+input, func(t *testing.T) {
+				if err := ep.fn([]byte(input)); err == nil {
+					t.Errorf("%s succeeded, want an error", ep.name)
+				}
+			})
+		}
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	nested := func(n int) []byte {
+		return []byte(strings.Repeat("l", n) + strings.Repeat("e", n))
+	}
+	tests := []struct {
+		name     string
+		input    []byte
+		maxDepth int
+		wantErr  bool
+	}{
+		{"at default limit", nested(DefaultMaxDepth), DefaultMaxDepth, false},
+		{"past default limit", nested(DefaultMaxDepth + 1), DefaultMaxDepth, true},
+		{"unterminated run", bytes.Repeat([]byte("l"), 2<<20), DefaultMaxDepth, true},
+		{"dictionaries", []byte("d1:ad1:ad1:aleeee"), 3, true},
+		{"dictionaries within limit", []byte("d1:ad1:ad1:aleeee"), 4, false},
+		{"limit disabled", nested(DefaultMaxDepth + 1), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, op := range []string{"Decode", "Skip"} {
+				d := newBytesDecoder(tt.input)
+				d.MaxDepth = tt.maxDepth
+				var err error
+				if op == "Decode" {
+					var v any
+					err = d.Decode(&v)
+				} else {
+					err = d.Skip()
+				}
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("%s() error = %v, wantErr %v", op, err, tt.wantErr)
+				}
+				var syntaxErr *SyntaxError
+				if err != nil && (!errors.As(err, &syntaxErr) || !strings.Contains(err.Error(), "nested deeper than")) {
+					t.Errorf("%s() error = %v, want a nesting SyntaxError", op, err)
+				}
+			}
+		})
+	}
+}
+
+func TestNewDecoderAuto(t *testing.T) {
+	doc := []byte("d4:name" + "80:" + strings.Repeat("x", 80) + "e")
+	compress := func(w io.WriteCloser, buf *bytes.Buffer) []byte {
+		w.Write(doc)
+		w.Close()
+		return buf.Bytes()
+	}
+	var gz, zl bytes.Buffer
+	tests := []struct {
+		name  string
+		input []byte
+		want  any
+	}{
+		{"plain", doc, map[string]any{"name": strings.Repeat("x", 80)}},
+		{"gzip", compress(gzip.NewWriter(&gz), &gz), map[string]any{"name": strings.Repeat("x", 80)}},
+		{"zlib", compress(zlib.NewWriter(&zl), &zl), map[string]any{"name": strings.Repeat("x", 80)}},
+		// "80:" reads as a zlib header with a preset dictionary.
+		{"plain string of length 80", []byte("80:" + strings.Repeat("y", 80)), strings.Repeat("y", 80)},
+		{"plain string of length 800", []byte("800:" + strings.Repeat("y", 800)), strings.Repeat("y", 800)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := NewDecoderAuto(io.NopCloser(bytes.NewReader(tt.input)))
+			if err != nil {
+				t.Fatalf("NewDecoderAuto() error = %v", err)
+			}
+			var got any
+			if err := d.Decode(&got); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// errAny stands for any non-nil error in test tables.
+var errAny = errors.New("any error")
+
+func errorMatches(err, want error) bool {
+	if want == errAny {
+		return err != nil
+	}
+	if want == nil {
+		return err == nil
+	}
+	return errors.Is(err, want)
+}
@@ -0,0 +1,129 @@
+package bencode
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"slices"
+	"strconv"
+)
+
+// IsCanonical reports whether data is already canonically encoded: every
+// dictionary has unique keys in sorted order, and integers and string lengths
+// have no leading zeros or negative zero. It returns an error if data is not
+// valid bencode.
+func IsCanonical(data []byte) (bool, error) {
+	canonical, err := canonicalize(data)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(canonical, data), nil
+}
+
+// Equal reports whether a and b decode to the same values, ignoring the
+// order of dictionary keys. Both are compared through their canonical
+// encoding, so strings compare by their bytes at every level and integers
+// with leading zeros by their value. It returns an error if either input is
+// not valid bencode.
+func Equal(a, b []byte) (bool, error) {
+	canonicalA, err := canonicalize(a)
+	if err != nil {
+		return false, err
+	}
+	canonicalB, err := canonicalize(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(canonicalA, canonicalB), nil
+}
+
+// canonicalize decodes every value in data and returns their canonical
+// encoding.
+func canonicalize(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, io.EOF
+	}
+
+	d := newBytesDecoder(data)
+	d.MaxIntDigits = 0          // Compare integers of any size
+	d.AcceptLeadingZeros = true // Normalize them instead of failing
+	values, err := d.decodeAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf []byte
+	for _, val := range values {
+		if buf, err = appendValue(buf, val); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// appendValue appends the canonical bencode encoding of a decoded value to
+// buf. Dictionary keys are written in sorted order at every level.
+func appendValue(buf []byte, data any) ([]byte, error) {
+	switch v := data.(type) {
+	case int:
+		buf = append(buf, integer)
+		buf = strconv.AppendInt(buf, int64(v), 10)
+		return append(buf, end), nil
+
+	case int64:
+		buf = append(buf, integer)
+		buf = strconv.AppendInt(buf, v, 10)
+		return append(buf, end), nil
+
+	case *big.Int:
+		buf = append(buf, integer)
+		buf = v.Append(buf, 10)
+		return append(buf, end), nil
+
+	case Number:
+		buf = append(buf, integer)
+		buf = append(buf, v...)
+		return append(buf, end), nil
+
+	case string:
+		buf = strconv.AppendInt(buf, int64(len(v)), 10)
+		buf = append(buf, colon)
+		return append(buf, v...), nil
+
+	case LazyString:
+		buf = strconv.AppendInt(buf, int64(len(v.data)), 10)
+		buf = append(buf, colon)
+		return append(buf, v.data...), nil
+
+	case []any:
+		buf = append(buf, lists)
+		for _, item := range v {
+			var err error
+			if buf, err = appendValue(buf, item); err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, end), nil
+
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+
+		buf = append(buf, dict)
+		for _, k := range keys {
+			buf, _ = appendValue(buf, k)
+			var err error
+			if buf, err = appendValue(buf, v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, end), nil
+
+	default:
+		return nil, fmt.Errorf("cannot encode value of type %T", data)
+	}
+}
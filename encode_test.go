@@ -0,0 +1,34 @@
+package bencode
+
+import "testing"
+
+func TestIsCanonical(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    bool
+		wantErr bool
+	}{
+		{"d1:ai1e1:bi2ee", true, false},
+		{"d1:bi2e1:ai1ee", false, false},
+		{"i03e", false, false},
+		{"i-0e", false, false},
+		{"03:abc", false, false},
+		{"i99999999999999999999e", true, false},
+		{"i-99999999999999999999e", true, false},
+		{"i0099999999999999999999e", false, false},
+		{"li1ei2ee", true, false},
+		{"i1", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := IsCanonical([]byte(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("IsCanonical() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("IsCanonical() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
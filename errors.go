@@ -0,0 +1,77 @@
+package bencode
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SyntaxError describes malformed bencode input.
+type SyntaxError struct {
+	Offset int // Byte offset at which the error was detected
+	msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at offset %d", e.msg, e.Offset)
+}
+
+// contextWindow is the number of bytes shown on each side of the error
+// offset by SyntaxError.Context.
+const contextWindow = 8
+
+// Context returns a hex dump of the bytes of data surrounding the error, with
+// the offending byte in brackets, followed by their printable ASCII form. It
+// shows [EOF] when the error occurred at the end of the input.
+func (e *SyntaxError) Context(data []byte) string {
+	start := min(max(e.Offset-contextWindow, 0), len(data))
+	stop := min(e.Offset+contextWindow+1, len(data))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%08x ", start)
+	for i := start; i < stop; i++ {
+		if i == e.Offset {
+			fmt.Fprintf(&b, " [%02x]", data[i])
+		} else {
+			fmt.Fprintf(&b, " %02x", data[i])
+		}
+	}
+	if e.Offset >= len(data) {
+		b.WriteString(" [EOF]")
+	}
+
+	b.WriteString("  |")
+	for i := start; i < stop; i++ {
+		c := data[i]
+		if c < ' ' || c > '~' {
+			c = '.'
+		}
+		b.WriteByte(c)
+	}
+	b.WriteByte('|')
+
+	return b.String()
+}
+
+// MultiError holds the errors collected while decoding with
+// Decoder.CollectErrors set.
+type MultiError []error
+
+func (m MultiError) Error() string {
+	msgs := make([]string, len(m))
+	for i, err := range m {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (m MultiError) Unwrap() []error {
+	return m
+}
+
+func (d *Decoder) syntaxError(format string, args ...any) error {
+	return d.syntaxErrorAt(d.curToken, format, args...)
+}
+
+func (d *Decoder) syntaxErrorAt(offset int, format string, args ...any) error {
+	return &SyntaxError{Offset: offset, msg: fmt.Sprintf(format, args...)}
+}
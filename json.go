@@ -0,0 +1,180 @@
+package bencode
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+)
+
+// base64Prefix marks JSON strings holding base64-encoded bencode strings.
+const base64Prefix = "base64:"
+
+// ToJSON converts bencode to JSON. Integers of any size become JSON numbers,
+// lists become arrays and dictionaries become objects. Several top-level
+// values become an array.
+//
+// Bencode strings are bytes, while JSON strings are text, so a string that
+// is valid UTF-8 is written as is and any other string is written as
+// "base64:" followed by its standard base64 encoding. Text strings that
+// happen to start with "base64:" are base64-encoded too, so every string
+// converts back unambiguously. Dictionary keys follow the same convention.
+func ToJSON(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, io.EOF
+	}
+
+	d := newBytesDecoder(data)
+	d.MaxIntDigits = 0 // JSON numbers have no size limit
+	results, err := d.decodeAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var val any = results
+	if len(results) == 1 {
+		val = results[0]
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(toJSONValue(val)); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// toJSONValue converts a decoded value into the form encoding/json writes.
+func toJSONValue(data any) any {
+	switch v := data.(type) {
+	case int:
+		return v
+	case Number:
+		return json.Number(v)
+	case string:
+		return jsonString(v)
+	case []any:
+		list := make([]any, len(v))
+		for i, item := range v {
+			list[i] = toJSONValue(item)
+		}
+		return list
+	case map[string]any:
+		dict := make(map[string]any, len(v))
+		for k, item := range v {
+			dict[jsonString(k)] = toJSONValue(item)
+		}
+		return dict
+	default:
+		return data
+	}
+}
+
+func jsonString(s string) string {
+	if utf8.ValidString(s) && !strings.HasPrefix(s, base64Prefix) {
+		return s
+	}
+	return base64Prefix + base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+// FromJSON converts JSON to canonical bencode, reversing ToJSON: strings
+// starting with "base64:" are decoded back to their bytes, in dictionary
+// keys too. Numbers must be integers, since bencode has none other; store
+// fractional values as strings. true and false become i1e and i0e. null
+// dictionary values are omitted, and null anywhere else is an error. So are
+// two keys that decode to the same bytes.
+func FromJSON(jsonData []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(jsonData))
+	dec.UseNumber()
+	var val any
+	if err := dec.Decode(&val); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after JSON value")
+	}
+
+	tree, err := fromJSONValue(val)
+	if err != nil {
+		return nil, err
+	}
+	return appendValue(nil, tree)
+}
+
+// fromJSONValue converts a value decoded by encoding/json into the form
+// appendValue writes.
+func fromJSONValue(data any) (any, error) {
+	switch v := data.(type) {
+	case nil:
+		return nil, fmt.Errorf("null has no bencode equivalent")
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case json.Number:
+		n, ok := new(big.Int).SetString(string(v), 10)
+		if !ok {
+			return nil, fmt.Errorf("JSON number %s is not an integer", v)
+		}
+		if n.IsInt64() && int64(int(n.Int64())) == n.Int64() {
+			return int(n.Int64()), nil
+		}
+		return n, nil
+	case string:
+		return fromJSONString(v)
+	case []any:
+		list := make([]any, len(v))
+		for i, item := range v {
+			var err error
+			if list[i], err = fromJSONValue(item); err != nil {
+				return nil, fmt.Errorf("list index %d: %w", i, err)
+			}
+		}
+		return list, nil
+	case map[string]any:
+		dict := make(map[string]any, len(v))
+		seen := make(map[string]string, len(v))
+		for k, item := range v {
+			key, err := fromJSONString(k)
+			if err != nil {
+				return nil, err
+			}
+			// Distinct JSON keys such as "a" and "base64:YQ==" can name the
+			// same bencode key; neither can be preferred, so reject both.
+			if other, dup := seen[key]; dup {
+				if other > k {
+					other, k = k, other
+				}
+				return nil, fmt.Errorf("keys %q and %q decode to the same key %q", other, k, key)
+			}
+			seen[key] = k
+			if item == nil {
+				continue
+			}
+			if dict[key], err = fromJSONValue(item); err != nil {
+				return nil, fmt.Errorf("key %q: %w", k, err)
+			}
+		}
+		return dict, nil
+	default:
+		return nil, fmt.Errorf("cannot convert JSON value of type %T", data)
+	}
+}
+
+func fromJSONString(s string) (string, error) {
+	encoded, ok := strings.CutPrefix(s, base64Prefix)
+	if !ok {
+		return s, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("invalid base64 string %q: %v", s, err)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,132 @@
+package bencode
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		json  string
+	}{
+		{"integer", "i-42e", `-42`},
+		{"text", "5:hello", `"hello"`},
+		{"non-UTF-8 string", "2:\xff\xfe", `"base64://4="`},
+		{"non-UTF-8 key", "d2:\xff\xfei1ee", `{"base64://4=":1}`},
+		{"text with base64 prefix", "10:base64:abc", `"base64:YmFzZTY0OmFiYw=="`},
+		{"key with base64 prefix", "d7:base64:i1ee", `{"base64:YmFzZTY0Og==":1}`},
+		{"wider than int64", "i123456789012345678901234567890e", `123456789012345678901234567890`},
+		{"below int64", "i-9223372036854775809e", `-9223372036854775809`},
+		{"empty list", "le", `[]`},
+		{"empty dict", "de", `{}`},
+		{"nested", "d4:listli1el0:ee3:mapd0:0:ee", `{"list":[1,[""]],"map":{"":""}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ToJSON([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("ToJSON() error = %v", err)
+			}
+			if string(got) != tt.json {
+				t.Errorf("ToJSON() = %s, want %s", got, tt.json)
+			}
+			back, err := FromJSON(got)
+			if err != nil {
+				t.Fatalf("FromJSON() error = %v", err)
+			}
+			if string(back) != tt.input {
+				t.Errorf("FromJSON() = %q, want %q", back, tt.input)
+			}
+		})
+	}
+}
+
+func TestToJSONMultipleValues(t *testing.T) {
+	got, err := ToJSON([]byte("i1e1:ale"))
+	if err != nil {
+		t.Fatalf("ToJSON() error = %v", err)
+	}
+	if want := `[1,"a",[]]`; string(got) != want {
+		t.Fatalf("ToJSON() = %s, want %s", got, want)
+	}
+	// The values come back as one list; the top-level sequence is not kept.
+	back, err := FromJSON(got)
+	if err != nil {
+		t.Fatalf("FromJSON() error = %v", err)
+	}
+	if want := "li1e1:alee"; string(back) != want {
+		t.Errorf("FromJSON() = %q, want %q", back, want)
+	}
+}
+
+func TestFromJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr string
+	}{
+		{"booleans", `[true,false]`, "li1ei0ee", ""},
+		{"null dictionary value", `{"a":null,"b":1}`, "d1:bi1ee", ""},
+		{"exponent integer", `1e3`, "", "not an integer"},
+		{"fraction", `1.5`, "", "not an integer"},
+		{"fraction in list", `[1,2.5]`, "", "list index 1: JSON number 2.5 is not an integer"},
+		{"null", `null`, "", "null has no bencode equivalent"},
+		{"null in list", `[null]`, "", "null has no bencode equivalent"},
+		{"invalid base64", `"base64:!!"`, "", "base64"},
+		{"trailing data", `1 2`, "", "unexpected data after JSON value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FromJSON([]byte(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("FromJSON() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FromJSON() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("FromJSON() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromJSONDuplicateKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr string
+	}{
+		{"distinct keys", `{"a":1,"base64:Yg==":2}`, "d1:ai1e1:bi2ee", ""},
+		{"plain and base64", `{"a":1,"base64:YQ==":2}`, "", `keys "a" and "base64:YQ==" decode to the same key "a"`},
+		{"null value", `{"a":null,"base64:YQ==":2}`, "", "decode to the same key"},
+		{"nested", `{"x":{"base64:YQ==":1,"a":[]}}`, "", "decode to the same key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FromJSON([]byte(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("FromJSON() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FromJSON() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("FromJSON() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
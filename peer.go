@@ -0,0 +1,66 @@
+package bencode
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+)
+
+// Peer is a peer address from a tracker response. A []Peer destination
+// accepts both forms trackers use for peer lists: a compact string of 6-byte
+// records, each a 4-byte IPv4 address followed by a big-endian port, and a
+// list of dictionaries with "ip" and "port" keys. With the "compact6" tag
+// option, compact strings hold 18-byte IPv6 records instead, as in BEP 7.
+type Peer struct {
+	IP   net.IP
+	Port uint16
+}
+
+var (
+	peerType      = reflect.TypeOf(Peer{})
+	peerSliceType = reflect.TypeOf([]Peer(nil))
+)
+
+// parseCompactPeers splits a compact peer string into records of an ipLen
+// byte address followed by a big-endian port.
+func parseCompactPeers(str string, ipLen int) ([]Peer, error) {
+	recordLen := ipLen + 2
+	if len(str)%recordLen != 0 {
+		return nil, fmt.Errorf("compact peers length %d is not a multiple of %d", len(str), recordLen)
+	}
+
+	peers := make([]Peer, len(str)/recordLen)
+	for i := range peers {
+		record := str[i*recordLen : (i+1)*recordLen]
+		peers[i] = Peer{
+			IP:   net.IP(record[:ipLen]),
+			Port: uint16(record[ipLen])<<8 | uint16(record[ipLen+1]),
+		}
+	}
+	return peers, nil
+}
+
+// setPeer fills a Peer from a dictionary with "ip" and "port" keys. The IP
+// must be a literal; names are not resolved while decoding.
+func setPeer(val reflect.Value, data any) error {
+	dict, ok := data.(map[string]any)
+	if !ok {
+		return fmt.Errorf("cannot decode %s into Peer", valueKind(data))
+	}
+
+	ipStr, ok := materialize(dict["ip"]).(string)
+	if !ok {
+		return fmt.Errorf("peer has no ip string")
+	}
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return fmt.Errorf("invalid peer IP %q", ipStr)
+	}
+	port, ok := dict["port"].(int)
+	if !ok || port < 0 || port > 65535 {
+		return fmt.Errorf("peer has no valid port")
+	}
+
+	val.Set(reflect.ValueOf(Peer{IP: ip, Port: uint16(port)}))
+	return nil
+}
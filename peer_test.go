@@ -0,0 +1,93 @@
+package bencode
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCompactPeers6(t *testing.T) {
+	// A tracker response carrying IPv6 peers in the "peers6" key, as in
+	// BEP 7: 2001:db8::1 port 6881 and ::ffff:10.0.0.1 port 80.
+	peers6 := "\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x1a\xe1" +
+		"\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\xff\xff\x0a\x00\x00\x01\x00\x50"
+	input := encodeTest(t, map[string]any{"interval": 1800, "peers6": peers6})
+	wantAddrs := []net.TCPAddr{
+		{IP: net.ParseIP("2001:db8::1"), Port: 6881},
+		{IP: net.ParseIP("::ffff:10.0.0.1"), Port: 80},
+	}
+
+	var addrs struct {
+		Peers6 []net.TCPAddr `bencode:"peers6,compact6"`
+	}
+	d := newBytesDecoder(input)
+	if err := d.Decode(&addrs); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !reflect.DeepEqual(addrs.Peers6, wantAddrs) {
+		t.Errorf("Decode() = %v, want %v", addrs.Peers6, wantAddrs)
+	}
+
+	var peers struct {
+		Peers6 []Peer `bencode:"peers6,compact6"`
+	}
+	d = newBytesDecoder(input)
+	if err := d.Decode(&peers); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	for i, want := range wantAddrs {
+		if got := peers.Peers6[i]; !got.IP.Equal(want.IP) || int(got.Port) != want.Port {
+			t.Errorf("peer %d = %v:%d, want %v", i, got.IP, got.Port, &want)
+		}
+	}
+
+	d = newBytesDecoder(encodeTest(t, map[string]any{"peers6": peers6[:20]}))
+	if err := d.Decode(&addrs); err == nil {
+		t.Errorf("Decode() of a truncated record succeeded")
+	}
+}
+
+func TestDecodePeers(t *testing.T) {
+	want := []Peer{
+		{IP: net.IPv4(10, 0, 0, 1), Port: 6881},
+		{IP: net.IPv4(192, 168, 1, 2), Port: 80},
+	}
+	tests := []struct {
+		name    string
+		peers   any
+		wantErr bool
+	}{
+		{"compact", "\x0a\x00\x00\x01\x1a\xe1\xc0\xa8\x01\x02\x00\x50", false},
+		{"dictionaries", []any{
+			map[string]any{"ip": "10.0.0.1", "peer id": "-XX0001-000000000000", "port": 6881},
+			map[string]any{"ip": "192.168.1.2", "port": 80},
+		}, false},
+		{"compact with partial record", "\x0a\x00\x00\x01\x1a", true},
+		{"dictionary without port", []any{map[string]any{"ip": "10.0.0.1"}}, true},
+		{"dictionary with host name", []any{map[string]any{"ip": "example.com", "port": 80}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp struct {
+				Peers []Peer `bencode:"peers"`
+			}
+			d := newBytesDecoder(encodeTest(t, map[string]any{"interval": 1800, "peers": tt.peers}))
+			err := d.Decode(&resp)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(resp.Peers) != len(want) {
+				t.Fatalf("Decode() = %v, want %v", resp.Peers, want)
+			}
+			for i := range want {
+				if !resp.Peers[i].IP.Equal(want[i].IP) || resp.Peers[i].Port != want[i].Port {
+					t.Errorf("peer %d = %v, want %v", i, resp.Peers[i], want[i])
+				}
+			}
+		})
+	}
+}
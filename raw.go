@@ -0,0 +1,125 @@
+package bencode
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// RawMessage is a raw encoded bencode value. As a destination it receives
+// the exact input bytes of the top-level value, of struct fields and of map
+// values decoded from a dictionary, which makes it useful for deferring the
+// decoding of parts of a document. Values without a known input position,
+// such as list elements, are re-encoded canonically instead.
+type RawMessage []byte
+
+// WriteTo writes the encoded value to w, implementing io.WriterTo so raw
+// values such as an "info" dictionary can be proxied without copying.
+func (m RawMessage) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(m)
+	return int64(n), err
+}
+
+// RawInt holds the text of an integer token exactly as it appears in the
+// input, sign included but without the surrounding 'i' and 'e', such as
+// "-42". Unlike Number, which may be normalized, it reproduces the original
+// formatting, which helps when reporting non-canonical integers. Like
+// RawMessage, it is taken from the input for struct fields and map values
+// and formatted from the decoded value elsewhere.
+type RawInt []byte
+
+var (
+	rawMessageType = reflect.TypeOf(RawMessage(nil))
+	rawIntType     = reflect.TypeOf(RawInt(nil))
+)
+
+// isRawType reports whether t receives bytes copied from the input.
+func isRawType(t reflect.Type) bool {
+	return t == rawMessageType || t == rawIntType
+}
+
+// setRaw stores the exact encoded bytes of a value in a RawMessage or RawInt.
+func setRaw(val reflect.Value, raw RawMessage) error {
+	if val.Type() == rawIntType {
+		if len(raw) < 2 || raw[0] != integer {
+			return fmt.Errorf("cannot decode non-integer value into RawInt")
+		}
+		raw = raw[1 : len(raw)-1]
+	}
+	val.SetBytes(raw)
+	return nil
+}
+
+// indirectType returns the type t points to, following every level of
+// pointers.
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// trackDictOffsets forgets the offsets recorded by earlier decodes and
+// decides whether to record them for decoding into v. Only RawMessage and
+// RawInt destinations in structs and maps use them, so other targets skip
+// the cost of a map insertion per dictionary.
+func (d *Decoder) trackDictOffsets(v any) {
+	d.dictOffsets = nil
+
+	seen := make(map[reflect.Type]bool)
+	d.recordDicts = needsDictOffsets(reflect.TypeOf(v), seen)
+	for _, t := range d.registeredTypes {
+		d.recordDicts = d.recordDicts || needsDictOffsets(t, seen)
+	}
+}
+
+// needsDictOffsets reports whether filling a value of type t may look up a
+// dictionary's raw entries.
+func needsDictOffsets(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return false // Recursive types are checked once
+	}
+	seen[t] = true
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return needsDictOffsets(t.Elem(), seen)
+	case reflect.Map:
+		return isRawType(t.Elem()) || needsDictOffsets(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.IsExported() && (isRawType(indirectType(field.Type)) || needsDictOffsets(field.Type, seen)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rawEntries returns the exact encoded bytes of every value in a dictionary
+// decoded by d, found by rescanning the input from where the dictionary
+// started. It returns nil for dictionaries d did not decode.
+func (d *Decoder) rawEntries(dict map[string]any) map[string]RawMessage {
+	start, ok := d.dictOffsets[reflect.ValueOf(dict).Pointer()]
+	if !ok {
+		return nil
+	}
+
+	s := Decoder{rawBytes: d.rawBytes, curToken: start + 1, AcceptLeadingZeros: d.AcceptLeadingZeros} // Skip the 'd'
+	entries := make(map[string]RawMessage, len(dict))
+	for s.curToken < len(s.rawBytes) && s.curTokenIs() != end {
+		key, err := s.decodeString()
+		if err != nil {
+			return nil
+		}
+		valueStart := s.curToken
+		if err := s.skip(); err != nil {
+			return nil
+		}
+		entries[key] = bytes.Clone(d.rawBytes[valueStart:s.curToken])
+	}
+
+	return entries
+}
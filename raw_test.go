@@ -0,0 +1,57 @@
+package bencode
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRawMessageEntries(t *testing.T) {
+	input := []byte("d4:infod6:lengthi03ee4:name3:abce")
+
+	t.Run("map", func(t *testing.T) {
+		d := newBytesDecoder([]byte("d4:infod6:lengthi3ee4:name3:abce"))
+		var got map[string]RawMessage
+		if err := d.Decode(&got); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+
+		want := map[string]any{"info": map[string]any{"length": 3}, "name": "abc"}
+		if len(got) != len(want) {
+			t.Fatalf("Decode() = %q, want keys of %v", got, want)
+		}
+		for key, raw := range got {
+			val, err := Decode[any](raw)
+			if err != nil || !reflect.DeepEqual(val, want[key]) {
+				t.Errorf("re-decoding %q = %v, %v, want %v", raw, val, err, want[key])
+			}
+		}
+	})
+
+	t.Run("nested struct", func(t *testing.T) {
+		var got struct {
+			Info struct {
+				Length RawInt `bencode:"length"`
+			} `bencode:"info"`
+		}
+		d := newBytesDecoder(input)
+		d.AcceptLeadingZeros = true
+		if err := d.Decode(&got); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		if string(got.Info.Length) != "03" {
+			t.Errorf("Length = %q, want %q", got.Info.Length, "03")
+		}
+	})
+
+	t.Run("no raw destinations", func(t *testing.T) {
+		d := newBytesDecoder(input)
+		d.AcceptLeadingZeros = true
+		var got map[string]any
+		if err := d.Decode(&got); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		if d.dictOffsets != nil {
+			t.Errorf("dictOffsets = %v, want nil", d.dictOffsets)
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package bencode
+
+// Rekey returns a copy of m with its keys renamed according to mapping. Keys
+// absent from mapping are kept as they are. If two keys end up with the same
+// name, the one renamed by mapping wins over one kept as is. Nested
+// dictionaries are shared with m, not renamed; see RekeyRecursive.
+func Rekey(m map[string]any, mapping map[string]string) map[string]any {
+	return rekey(m, mapping, false)
+}
+
+// RekeyRecursive is like Rekey but also renames the keys of dictionaries
+// nested in m, including those inside lists.
+func RekeyRecursive(m map[string]any, mapping map[string]string) map[string]any {
+	return rekey(m, mapping, true)
+}
+
+func rekey(m map[string]any, mapping map[string]string, recursive bool) map[string]any {
+	if m == nil {
+		return nil
+	}
+
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		if recursive {
+			v = rekeyValue(v, mapping)
+		}
+		if renamed, ok := mapping[k]; ok {
+			out[renamed] = v
+		} else if _, taken := out[k]; !taken {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func rekeyValue(data any, mapping map[string]string) any {
+	switch v := data.(type) {
+	case map[string]any:
+		return rekey(v, mapping, true)
+	case []any:
+		list := make([]any, len(v))
+		for i, item := range v {
+			list[i] = rekeyValue(item, mapping)
+		}
+		return list
+	default:
+		return data
+	}
+}
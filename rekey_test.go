@@ -0,0 +1,91 @@
+package bencode
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRekey(t *testing.T) {
+	nested := func() map[string]any {
+		return map[string]any{
+			"a": map[string]any{"a": 1},
+			"l": []any{map[string]any{"a": 2}, "a", []any{map[string]any{"a": 3}}},
+		}
+	}
+	tests := []struct {
+		name          string
+		input         map[string]any
+		mapping       map[string]string
+		want          map[string]any
+		wantRecursive map[string]any
+	}{
+		{
+			name:          "rename",
+			input:         map[string]any{"a": 1, "b": 2},
+			mapping:       map[string]string{"a": "x"},
+			want:          map[string]any{"x": 1, "b": 2},
+			wantRecursive: map[string]any{"x": 1, "b": 2},
+		},
+		{
+			name:          "swap",
+			input:         map[string]any{"a": 1, "b": 2},
+			mapping:       map[string]string{"a": "b", "b": "a"},
+			want:          map[string]any{"a": 2, "b": 1},
+			wantRecursive: map[string]any{"a": 2, "b": 1},
+		},
+		{
+			name:          "renamed key wins over kept key",
+			input:         map[string]any{"a": 1, "b": 2},
+			mapping:       map[string]string{"a": "b"},
+			want:          map[string]any{"b": 1},
+			wantRecursive: map[string]any{"b": 1},
+		},
+		{
+			name:          "nil map",
+			input:         nil,
+			mapping:       map[string]string{"a": "x"},
+			want:          nil,
+			wantRecursive: nil,
+		},
+		{
+			name:          "nil mapping",
+			input:         map[string]any{"a": 1},
+			mapping:       nil,
+			want:          map[string]any{"a": 1},
+			wantRecursive: map[string]any{"a": 1},
+		},
+		{
+			name:    "nested dictionaries and lists",
+			input:   nested(),
+			mapping: map[string]string{"a": "x"},
+			want: map[string]any{
+				"x": map[string]any{"a": 1},
+				"l": []any{map[string]any{"a": 2}, "a", []any{map[string]any{"a": 3}}},
+			},
+			wantRecursive: map[string]any{
+				"x": map[string]any{"x": 1},
+				"l": []any{map[string]any{"x": 2}, "a", []any{map[string]any{"x": 3}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Rekey(tt.input, tt.mapping); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Rekey() = %v, want %v", got, tt.want)
+			}
+			if got := RekeyRecursive(tt.input, tt.mapping); !reflect.DeepEqual(got, tt.wantRecursive) {
+				t.Errorf("RekeyRecursive() = %v, want %v", got, tt.wantRecursive)
+			}
+		})
+	}
+}
+
+func TestRekeyRecursiveLeavesInputUnchanged(t *testing.T) {
+	input := map[string]any{"d": map[string]any{"a": 1}, "l": []any{map[string]any{"a": 2}}}
+	RekeyRecursive(input, map[string]string{"a": "x"})
+	want := map[string]any{"d": map[string]any{"a": 1}, "l": []any{map[string]any{"a": 2}}}
+	if !reflect.DeepEqual(input, want) {
+		t.Errorf("input = %v after RekeyRecursive, want %v", input, want)
+	}
+}
@@ -0,0 +1,43 @@
+package bencode
+
+import "bytes"
+
+// MinimalReproducer shrinks an input that fails to decode into a smaller
+// input that still fails, for reporting decoder bugs. It repeatedly removes
+// chunks of decreasing size, keeping every removal after which decoding
+// still returns an error. The result fails to decode but may fail with a
+// different error than the original. Inputs that decode successfully are
+// returned unchanged.
+func MinimalReproducer(data []byte) []byte {
+	if !failsToDecode(data) {
+		return data
+	}
+
+	current := bytes.Clone(data)
+	for chunk := len(current) / 2; chunk > 0; {
+		removed := false
+		for start := 0; start+chunk <= len(current); {
+			candidate := append(bytes.Clone(current[:start]), current[start+chunk:]...)
+			if failsToDecode(candidate) {
+				current = candidate
+				removed = true
+				continue // Try removing the next chunk at the same position
+			}
+			start += chunk
+		}
+		if !removed {
+			chunk /= 2
+		}
+	}
+
+	return current
+}
+
+func failsToDecode(data []byte) bool {
+	if len(data) == 0 {
+		return false // Empty input is rejected before decoding starts
+	}
+	d := newBytesDecoder(data)
+	_, err := d.decodeAll()
+	return err != nil
+}
@@ -0,0 +1,33 @@
+package bencode
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMinimalReproducer(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantLen int
+	}{
+		{"li1ei2e", 1},
+		{"li1e\x00", 1},
+		{"d3:fooi1e3:bar", 1},
+		{"i1e\x00\x00i2e", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got := MinimalReproducer([]byte(tt.input))
+			if len(got) != tt.wantLen || !failsToDecode(got) {
+				t.Errorf("MinimalReproducer() = %q, want a failing input of %d bytes", got, tt.wantLen)
+			}
+		})
+	}
+
+	// Inputs that decode are returned unchanged.
+	valid := []byte("li1ei2ee")
+	if got := MinimalReproducer(valid); !bytes.Equal(got, valid) {
+		t.Errorf("MinimalReproducer() = %q, want %q", got, valid)
+	}
+}
@@ -0,0 +1,105 @@
+package bencode
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// ScanInt parses a single integer token (i...e) from the front of data and
+// returns its value and the number of bytes consumed. It rejects leading
+// zeros, negative zero and values that overflow an int64, and does not
+// allocate.
+func ScanInt(data []byte) (val int64, n int, err error) {
+	if len(data) == 0 {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	if data[0] != integer {
+		return 0, 0, fmt.Errorf("expected integer, found %q", data[0])
+	}
+
+	n = 1
+	negative := false
+	if n < len(data) && data[n] == '-' {
+		negative = true
+		n++
+	}
+
+	start := n
+	var abs uint64
+	for ; n < len(data) && data[n] != end; n++ {
+		c := data[n]
+		if c < asciiZero || c > asciiNine {
+			return 0, 0, fmt.Errorf("invalid character in integer: %c", c)
+		}
+		if abs > (math.MaxUint64-9)/10 {
+			return 0, 0, fmt.Errorf("integer overflows int64")
+		}
+		abs = abs*10 + uint64(c-asciiZero)
+	}
+
+	if n >= len(data) {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+
+	digits := data[start:n]
+	switch {
+	case len(digits) == 0:
+		return 0, 0, fmt.Errorf("integer has no digits")
+	case len(digits) > 1 && digits[0] == asciiZero:
+		return 0, 0, fmt.Errorf("integer has leading zeros")
+	case negative && abs == 0:
+		return 0, 0, fmt.Errorf("negative zero is not a valid integer")
+	}
+
+	if negative {
+		if abs > math.MaxInt64+1 {
+			return 0, 0, fmt.Errorf("integer overflows int64")
+		}
+		val = -int64(abs-1) - 1
+	} else {
+		if abs > math.MaxInt64 {
+			return 0, 0, fmt.Errorf("integer overflows int64")
+		}
+		val = int64(abs)
+	}
+
+	return val, n + 1, nil
+}
+
+// ScanString parses a single length-prefixed string from the front of data
+// and returns its contents and the number of bytes consumed. The returned
+// slice aliases data. Lengths with leading zeros are rejected.
+func ScanString(data []byte) (val []byte, n int, err error) {
+	if len(data) == 0 {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	var length int
+	for ; n < len(data) && data[n] != colon; n++ {
+		c := data[n]
+		if c < asciiZero || c > asciiNine {
+			return nil, 0, fmt.Errorf("invalid character in string length: %c", c)
+		}
+		if length > (len(data)-int(c-asciiZero))/10 {
+			return nil, 0, io.ErrUnexpectedEOF // Longer than the remaining input
+		}
+		length = length*10 + int(c-asciiZero)
+	}
+
+	switch {
+	case n >= len(data):
+		return nil, 0, io.ErrUnexpectedEOF
+	case n == 0:
+		return nil, 0, fmt.Errorf("string has no length")
+	case n > 1 && data[0] == asciiZero:
+		return nil, 0, fmt.Errorf("string length has leading zeros")
+	}
+
+	n++ // Skip the ':'
+	if length > len(data)-n {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	return data[n : n+length], n + length, nil
+}
@@ -0,0 +1,341 @@
+package bencode
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// MetaInfo models the standard fields of a .torrent file.
+type MetaInfo struct {
+	Announce     string     `bencode:"announce"`
+	AnnounceList [][]string `bencode:"announce-list"`
+	Comment      string     `bencode:"comment"`
+	CreatedBy    string     `bencode:"created by"`
+	CreationDate int64      `bencode:"creation date"` // Unix time
+	// Encoding names the character set of strings such as Comment and
+	// Info.Name, usually "UTF-8". The *UTF8 fields, when present, hold
+	// UTF-8 versions of strings written in another encoding.
+	Encoding string `bencode:"encoding"`
+	Info     Info   `bencode:"info"`
+	// InfoHash is the SHA1 of the raw "info" dictionary.
+	InfoHash [20]byte `bencode:",infohash"`
+}
+
+// Info is the "info" dictionary of a torrent. Single-file torrents set Length,
+// multi-file torrents set Files, and Name is the file or directory name
+// respectively.
+type Info struct {
+	Name        string `bencode:"name"`
+	NameUTF8    string `bencode:"name.utf-8"`
+	PieceLength int64  `bencode:"piece length"`
+	Pieces      []byte `bencode:"pieces"` // Concatenated 20-byte SHA1 hashes
+	Private     bool   `bencode:"private"`
+	Length      int64  `bencode:"length"`
+	MD5Sum      string `bencode:"md5sum"`
+	Files       []File `bencode:"files"`
+}
+
+// File is an entry of a multi-file torrent's "files" list.
+type File struct {
+	Length   int64    `bencode:"length"`
+	Path     []string `bencode:"path"`
+	PathUTF8 []string `bencode:"path.utf-8"`
+	MD5Sum   string   `bencode:"md5sum"`
+}
+
+// IsMultiFile reports whether the torrent uses the multi-file layout.
+func (i *Info) IsMultiFile() bool {
+	return len(i.Files) > 0
+}
+
+// TotalLength returns the combined length of all files in the torrent.
+func (i *Info) TotalLength() int64 {
+	if !i.IsMultiFile() {
+		return i.Length
+	}
+
+	var total int64
+	for _, f := range i.Files {
+		total += f.Length
+	}
+	return total
+}
+
+// ParseTorrent decodes a .torrent file and computes its info-hash. The info
+// dictionary must describe its files with a "length" key, possibly zero, a
+// "files" list, or a BitTorrent v2 "file tree", which Info leaves undecoded;
+// see ParseFileTreeV2.
+func ParseTorrent(data []byte) (*MetaInfo, error) {
+	mi, _, err := parseTorrent(data)
+	return mi, err
+}
+
+// ParseTorrentWithRaw is like ParseTorrent but also returns the exact bytes
+// of the info dictionary and its info-hash, all from a single decoding pass.
+// infoRaw aliases data.
+func ParseTorrentWithRaw(data []byte) (mi *MetaInfo, infoRaw []byte, hash [20]byte, err error) {
+	mi, d, err := parseTorrent(data)
+	if err != nil {
+		return nil, nil, hash, err
+	}
+	return mi, d.rawBytes[d.infoStart:d.infoEnd], mi.InfoHash, nil
+}
+
+func parseTorrent(data []byte) (*MetaInfo, *Decoder, error) {
+	d := newBytesDecoder(data)
+	var mi MetaInfo
+	if err := d.Decode(&mi); err != nil {
+		return nil, nil, err
+	}
+
+	if d.infoEnd == 0 {
+		return nil, nil, fmt.Errorf("torrent has no info dictionary")
+	}
+	hasLayout := false
+	for _, key := range []string{"length", "files", "file tree"} {
+		_, ok, err := d.rawDictValue(d.infoStart, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		hasLayout = hasLayout || ok
+	}
+	if !hasLayout {
+		return nil, nil, fmt.Errorf("info dictionary has no length, files or file tree")
+	}
+
+	return &mi, &d, nil
+}
+
+// DedupeAnnounceList returns a copy of an announce-list with repeated tracker
+// URLs removed. The first occurrence of a URL is kept, so a tracker listed in
+// several tiers stays in the earliest one, and tiers left empty are dropped.
+func DedupeAnnounceList(list [][]string) [][]string {
+	seen := make(map[string]bool)
+	var deduped [][]string
+	for _, tier := range list {
+		var kept []string
+		for _, url := range tier {
+			if seen[url] {
+				continue
+			}
+			seen[url] = true
+			kept = append(kept, url)
+		}
+		if len(kept) > 0 {
+			deduped = append(deduped, kept)
+		}
+	}
+	return deduped
+}
+
+// InfoHashV2 returns the BitTorrent v2 (BEP 52) info-hash: the SHA256 of the
+// raw bytes of the value stored under key, usually "info", in the top-level
+// dictionary of the decoder's input. It can be called before or after
+// Decode.
+func (d *Decoder) InfoHashV2(key string) ([32]byte, error) {
+	raw, err := d.topLevelValue(key)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return sha256.Sum256(raw), nil
+}
+
+// InfoHashes returns both the v1 (SHA1) and v2 (SHA256) info-hashes of the
+// value stored under key, for hybrid torrents that carry both.
+func (d *Decoder) InfoHashes(key string) (v1 [20]byte, v2 [32]byte, err error) {
+	raw, err := d.topLevelValue(key)
+	if err != nil {
+		return v1, v2, err
+	}
+	return sha1.Sum(raw), sha256.Sum256(raw), nil
+}
+
+// topLevelValue returns the raw bytes of the value stored under key in the
+// dictionary at the start of the decoder's input.
+func (d *Decoder) topLevelValue(key string) ([]byte, error) {
+	raw, ok, err := d.rawDictValue(d.valuesStart, key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("dictionary has no %q key", key)
+	}
+	return raw, nil
+}
+
+// rawDictValue returns the raw bytes of the value stored under key in the
+// dictionary starting at the given offset of the decoder's input, and
+// whether the key is present.
+func (d *Decoder) rawDictValue(offset int, key string) ([]byte, bool, error) {
+	s := Decoder{rawBytes: d.rawBytes, curToken: offset, AcceptLeadingZeros: d.AcceptLeadingZeros}
+	if s.curToken >= len(s.rawBytes) || s.curTokenIs() != dict {
+		return nil, false, fmt.Errorf("input is not a dictionary")
+	}
+
+	s.advance() // Skip the 'd'
+	for s.curToken < len(s.rawBytes) && s.curTokenIs() != end {
+		k, err := s.decodeString()
+		if err != nil {
+			return nil, false, err
+		}
+		start := s.curToken
+		if err := s.skip(); err != nil {
+			return nil, false, err
+		}
+		if k == key {
+			return d.rawBytes[start:s.curToken], true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// FileNode is a file or directory in the tree built by BuildFileTree.
+type FileNode struct {
+	Name     string
+	Path     []string    // Components below the root node; empty for the root
+	Length   int64       // For directories, the total length of their files
+	Children []*FileNode // In the order first seen; nil for files
+
+	// PiecesRoot is the root of a file's piece hash tree in BEP 52
+	// torrents, as set by ParseFileTreeV2.
+	PiecesRoot []byte
+}
+
+// IsDir reports whether the node is a directory.
+func (n *FileNode) IsDir() bool {
+	return n.Children != nil
+}
+
+// BuildFileTree arranges the files of an info dictionary into a directory
+// tree rooted at the torrent's name. info may be an Info, a *Info or a
+// decoded map[string]any. A single-file torrent yields a tree with just one
+// file node. Files sharing leading path components share directory nodes.
+func BuildFileTree(info any) (*FileNode, error) {
+	var i Info
+	switch v := info.(type) {
+	case Info:
+		i = v
+	case *Info:
+		i = *v
+	case map[string]any:
+		d := newBytesDecoder(nil)
+		if err := d.fillStruct(v, reflect.ValueOf(&i)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("cannot build a file tree from %T", info)
+	}
+
+	if !i.IsMultiFile() {
+		return &FileNode{Name: i.Name, Length: i.Length}, nil
+	}
+
+	root := &FileNode{Name: i.Name, Children: []*FileNode{}}
+	for idx, f := range i.Files {
+		if len(f.Path) == 0 {
+			return nil, fmt.Errorf("file %d has an empty path", idx)
+		}
+
+		dir := root
+		for depth, name := range f.Path {
+			dir.Length += f.Length
+			child := dir.child(name)
+			last := depth == len(f.Path)-1
+			switch {
+			case child == nil:
+				child = &FileNode{Name: name, Path: slices.Clone(f.Path[:depth+1])}
+				if !last {
+					child.Children = []*FileNode{}
+				}
+				dir.Children = append(dir.Children, child)
+			case last || !child.IsDir():
+				return nil, fmt.Errorf("file %d: path %q conflicts with an earlier file", idx, strings.Join(f.Path[:depth+1], "/"))
+			}
+			dir = child
+		}
+		dir.Length = f.Length
+	}
+
+	return root, nil
+}
+
+func (n *FileNode) child(name string) *FileNode {
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// ParseFileTreeV2 builds a FileNode tree from the "file tree" dictionary of
+// a decoded BitTorrent v2 (BEP 52) info dictionary. In a file tree, each
+// directory is a dictionary keyed by entry name, and a file is a dictionary
+// whose only key is the empty string, mapping to its "length" and "pieces
+// root". Children are sorted by name. A tree holding just one file named
+// after the torrent yields that file node alone, as for v1 single-file
+// torrents.
+func ParseFileTreeV2(info any) (*FileNode, error) {
+	dict, ok := info.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("cannot build a file tree from %T", info)
+	}
+	name, _ := materialize(dict["name"]).(string)
+	tree, ok := dict["file tree"].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("info dictionary has no file tree")
+	}
+
+	root := &FileNode{Name: name}
+	if err := root.addTreeEntries(tree, nil); err != nil {
+		return nil, err
+	}
+	if len(root.Children) == 1 && !root.Children[0].IsDir() && root.Children[0].Name == name {
+		file := root.Children[0]
+		file.Path = nil
+		return file, nil
+	}
+	return root, nil
+}
+
+// addTreeEntries adds the entries of a v2 file tree directory to n.
+func (n *FileNode) addTreeEntries(tree map[string]any, path []string) error {
+	n.Children = make([]*FileNode, 0, len(tree))
+	for _, name := range slices.Sorted(maps.Keys(tree)) {
+		childPath := append(slices.Clip(path), name)
+		entry, ok := tree[name].(map[string]any)
+		if !ok {
+			return fmt.Errorf("file tree entry %q is not a dictionary", strings.Join(childPath, "/"))
+		}
+		if name == "" {
+			return fmt.Errorf("file tree entry %q has an empty name", strings.Join(path, "/"))
+		}
+
+		child := &FileNode{Name: name, Path: childPath}
+		if attrs, ok := entry[""]; ok {
+			if len(entry) != 1 {
+				return fmt.Errorf("file tree entry %q mixes file and directory keys", strings.Join(childPath, "/"))
+			}
+			var f struct {
+				Length     int64  `bencode:"length"`
+				PiecesRoot []byte `bencode:"pieces root"`
+			}
+			d := newBytesDecoder(nil)
+			if err := d.fillStruct(attrs, reflect.ValueOf(&f)); err != nil {
+				return fmt.Errorf("file %q: %w", strings.Join(childPath, "/"), err)
+			}
+			child.Length, child.PiecesRoot = f.Length, f.PiecesRoot
+		} else if err := child.addTreeEntries(entry, childPath); err != nil {
+			return err
+		}
+
+		n.Length += child.Length
+		n.Children = append(n.Children, child)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package bencode
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// encodeTest encodes a value tree built from maps, lists, strings and ints.
+func encodeTest(t testing.TB, v any) []byte {
+	t.Helper()
+	data, err := appendValue(nil, v)
+	if err != nil {
+		t.Fatalf("encoding test input: %v", err)
+	}
+	return data
+}
+
+func TestParseTorrentLayouts(t *testing.T) {
+	pieces := string(make([]byte, 20))
+	tests := []struct {
+		name    string
+		info    map[string]any
+		wantErr bool
+	}{
+		{"single file", map[string]any{"name": "a", "piece length": 16384, "pieces": pieces, "length": 5}, false},
+		{"empty single file", map[string]any{"name": "a", "piece length": 16384, "pieces": "", "length": 0}, false},
+		{"multi file", map[string]any{"name": "d", "piece length": 16384, "pieces": pieces, "files": []any{
+			map[string]any{"length": 5, "path": []any{"a"}},
+		}}, false},
+		{"v2 file tree", map[string]any{"name": "a", "piece length": 16384, "meta version": 2, "file tree": map[string]any{
+			"a": map[string]any{"": map[string]any{"length": 5, "pieces root": string(make([]byte, 32))}},
+		}}, false},
+		{"no layout", map[string]any{"name": "a", "piece length": 16384, "pieces": pieces}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := encodeTest(t, map[string]any{"announce": "http://tracker/announce", "info": tt.info})
+			mi, err := ParseTorrent(data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTorrent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if want := sha1.Sum(encodeTest(t, tt.info)); mi.InfoHash != want {
+				t.Errorf("InfoHash = %x, want %x", mi.InfoHash, want)
+			}
+		})
+	}
+
+	if _, err := ParseTorrent([]byte("d8:announce3:urle")); err == nil {
+		t.Errorf("ParseTorrent() without info succeeded, want an error")
+	}
+}
+
+func TestDedupeAnnounceList(t *testing.T) {
+	tests := []struct {
+		name string
+		list [][]string
+		want [][]string
+	}{
+		{"no duplicates", [][]string{{"a", "b"}, {"c"}}, [][]string{{"a", "b"}, {"c"}}},
+		{"within a tier", [][]string{{"a", "b", "a"}, {"c", "c"}}, [][]string{{"a", "b"}, {"c"}}},
+		{"across tiers", [][]string{{"a"}, {"b", "a"}, {"c", "b"}}, [][]string{{"a"}, {"b"}, {"c"}}},
+		{"emptied tier dropped", [][]string{{"a"}, {"a"}, {"b"}}, [][]string{{"a"}, {"b"}}},
+		{"empty tier dropped", [][]string{{}, {"a"}}, [][]string{{"a"}}},
+		{"nil", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DedupeAnnounceList(tt.list); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DedupeAnnounceList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildFileTree(t *testing.T) {
+	files := []File{
+		{Length: 1, Path: []string{"a", "b", "x"}},
+		{Length: 2, Path: []string{"a", "b", "y"}},
+		{Length: 3, Path: []string{"a", "a", "a"}}, // Same name at every level
+		{Length: 4, Path: []string{"c"}},
+	}
+	want := &FileNode{Name: "root", Length: 10, Children: []*FileNode{
+		{Name: "a", Path: []string{"a"}, Length: 6, Children: []*FileNode{
+			{Name: "b", Path: []string{"a", "b"}, Length: 3, Children: []*FileNode{
+				{Name: "x", Path: []string{"a", "b", "x"}, Length: 1},
+				{Name: "y", Path: []string{"a", "b", "y"}, Length: 2},
+			}},
+			{Name: "a", Path: []string{"a", "a"}, Length: 3, Children: []*FileNode{
+				{Name: "a", Path: []string{"a", "a", "a"}, Length: 3},
+			}},
+		}},
+		{Name: "c", Path: []string{"c"}, Length: 4},
+	}}
+
+	got, err := BuildFileTree(Info{Name: "root", Files: files})
+	if err != nil {
+		t.Fatalf("BuildFileTree() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("BuildFileTree() = %s, want %s", formatTree(got), formatTree(want))
+	}
+
+	// A decoded info dictionary builds the same tree.
+	var info map[string]any
+	d := newBytesDecoder(encodeTest(t, map[string]any{"name": "root", "files": []any{
+		map[string]any{"length": 1, "path": []any{"a", "b", "x"}},
+		map[string]any{"length": 2, "path": []any{"a", "b", "y"}},
+		map[string]any{"length": 3, "path": []any{"a", "a", "a"}},
+		map[string]any{"length": 4, "path": []any{"c"}},
+	}}))
+	if err := d.Decode(&info); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if got, err := BuildFileTree(info); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("BuildFileTree(map) = %s, %v, want %s", formatTree(got), err, formatTree(want))
+	}
+}
+
+func TestBuildFileTreeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []File
+	}{
+		{"duplicate file", []File{{Path: []string{"a"}}, {Path: []string{"a"}}}},
+		{"file inside file", []File{{Path: []string{"a"}}, {Path: []string{"a", "b"}}}},
+		{"file over directory", []File{{Path: []string{"a", "b"}}, {Path: []string{"a"}}}},
+		{"empty path", []File{{Path: []string{"a"}}, {Path: nil}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := BuildFileTree(&Info{Name: "root", Files: tt.files}); err == nil {
+				t.Errorf("BuildFileTree() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestBuildFileTreeSingleFile(t *testing.T) {
+	got, err := BuildFileTree(Info{Name: "file.iso", Length: 42})
+	want := &FileNode{Name: "file.iso", Length: 42}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("BuildFileTree() = %s, %v, want %s", formatTree(got), err, formatTree(want))
+	}
+}
+
+// formatTree describes a file tree on one line for test failures.
+func formatTree(n *FileNode) string {
+	if n == nil {
+		return "<nil>"
+	}
+	s := fmt.Sprintf("%s(%d)", n.Name, n.Length)
+	if n.IsDir() {
+		children := make([]string, len(n.Children))
+		for i, c := range n.Children {
+			children[i] = formatTree(c)
+		}
+		s += "[" + strings.Join(children, " ") + "]"
+	}
+	return s
+}
+
+func TestInfoHashes(t *testing.T) {
+	var root strings.Builder
+	for i := range 32 {
+		root.WriteByte(byte(i))
+	}
+	v2Info := "d9:file treed4:testd0:d6:lengthi5e11:pieces root32:" + root.String() +
+		"eee12:meta versioni2e4:name4:test12:piece lengthi16384ee"
+
+	tests := []struct {
+		name   string
+		input  string
+		wantV1 string
+		wantV2 string
+	}{
+		{
+			"v2 info dictionary",
+			"d8:announce3:url4:info" + v2Info + "e",
+			"43653c65a7d82e626d6e1ceeeedfd06f9a2d6489",
+			"dd824cb9762211976216aaa32eb92bb3974550d93c72d90df2ff335e2d86a6ff",
+		},
+		{
+			// The raw bytes are hashed, so unsorted keys are not reordered.
+			"unsorted keys",
+			"d4:infod4:name1:x12:meta versioni2eee",
+			"73b79effc7def553a2a8ea9ea63ebe5065435d9e",
+			"94345265fafe2bdf12bd23de2cf7b77d44144ceab410ca45bd84d72d9be379cb",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newBytesDecoder([]byte(tt.input))
+			v2, err := d.InfoHashV2("info")
+			if err != nil {
+				t.Fatalf("InfoHashV2() error = %v", err)
+			}
+			if got := hex.EncodeToString(v2[:]); got != tt.wantV2 {
+				t.Errorf("InfoHashV2() = %s, want %s", got, tt.wantV2)
+			}
+
+			v1, v2, err := d.InfoHashes("info")
+			if err != nil {
+				t.Fatalf("InfoHashes() error = %v", err)
+			}
+			if got := hex.EncodeToString(v1[:]); got != tt.wantV1 {
+				t.Errorf("InfoHashes() v1 = %s, want %s", got, tt.wantV1)
+			}
+			if got := hex.EncodeToString(v2[:]); got != tt.wantV2 {
+				t.Errorf("InfoHashes() v2 = %s, want %s", got, tt.wantV2)
+			}
+		})
+	}
+
+	d := newBytesDecoder([]byte("d8:announce3:urle"))
+	if _, err := d.InfoHashV2("info"); err == nil {
+		t.Errorf("InfoHashV2() without an info key succeeded")
+	}
+}